@@ -8,7 +8,9 @@ require (
 	github.com/gophercloud/gophercloud/v2 v2.0.0-rc.3
 	github.com/opentelekomcloud/gophertelekomcloud v0.9.3
 	github.com/prometheus/client_golang v1.19.1
+	github.com/prometheus/client_model v0.6.1
 	github.com/prometheus/common v0.54.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -17,10 +19,8 @@ require (
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/go-logfmt/logfmt v0.5.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
-	github.com/prometheus/client_model v0.6.1 // indirect
 	github.com/prometheus/procfs v0.12.0 // indirect
 	github.com/xhit/go-str2duration/v2 v2.1.0 // indirect
 	golang.org/x/sys v0.21.0 // indirect
 	google.golang.org/protobuf v1.34.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -2,25 +2,411 @@ package internal
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
 	"github.com/go-kit/log/level"
 	"github.com/gophercloud/gophercloud/v2"
 	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/gophercloud/v2/openstack/config"
+	"github.com/gophercloud/gophercloud/v2/openstack/config/clouds"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/applicationcredentials"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
+	"gopkg.in/yaml.v2"
 )
 
-func authenticateOpenStack() (*gophercloud.ProviderClient, error) {
-	ctx := context.Background()
-	// Load OpenStack client options from the environment
+// Auth types which are not handled by gophercloud itself
+const oidcClientCredentialsAuthType = "v3oidcclientcredentials"
+
+// CloudConfig holds everything needed to build the OpenStack clients for a
+// single cloud, either taken from clouds.yaml or from the OS_* environment.
+type CloudConfig struct {
+	Name                string
+	AuthType            string
+	AuthOptions         gophercloud.AuthOptions
+	OIDC                OIDCClientCredentials
+	EndpointOpts        gophercloud.EndpointOpts
+	TLSConfig           *tls.Config
+	BlockStorageVersion string
+	// AK/SK credentials, only used for OBS on OTC
+	AccessKey string
+	SecretKey string
+}
+
+// OIDCClientCredentials holds the options of the v3oidcclientcredentials auth
+// type, named like in keystoneauth
+type OIDCClientCredentials struct {
+	IdentityProvider    string `yaml:"identity_provider"`
+	Protocol            string `yaml:"protocol"`
+	ClientID            string `yaml:"client_id"`
+	ClientSecret        string `yaml:"client_secret"`
+	Scope               string `yaml:"openid_scope"`
+	AccessTokenEndpoint string `yaml:"access_token_endpoint"`
+	DiscoveryEndpoint   string `yaml:"discovery_endpoint"`
+}
+
+// cloudsFile holds the clouds.yaml fields the gophercloud parser does not return
+type cloudsFile struct {
+	Clouds map[string]struct {
+		AuthType         string `yaml:"auth_type"`
+		VolumeAPIVersion string `yaml:"volume_api_version"`
+		Auth             struct {
+			OIDCClientCredentials `yaml:",inline"`
+			AccessKey             string `yaml:"ak"`
+			SecretKey             string `yaml:"sk"`
+		} `yaml:"auth"`
+	} `yaml:"clouds"`
+}
+
+// LoadCloudConfig loads the named cloud from clouds.yaml/secure.yaml. When no
+// cloud name is given the configuration is read from the environment.
+func LoadCloudConfig(cloudName string) (*CloudConfig, error) {
+	if cloudName == "" {
+		return cloudConfigFromEnv()
+	}
+
+	cloudsPath, err := findCloudsFile()
+	if err != nil {
+		return nil, err
+	}
+
+	level.Debug(logger).Log("message", "Parsing clouds.yaml", "cloud", cloudName, "path", cloudsPath)
+	authOptions, endpointOpts, tlsConfig, err := clouds.Parse(clouds.WithCloudName(cloudName), clouds.WithLocations(cloudsPath))
+	if err != nil {
+		return nil, err
+	}
+
+	cloudConfig := &CloudConfig{
+		Name:         cloudName,
+		AuthOptions:  authOptions,
+		EndpointOpts: endpointOpts,
+		TLSConfig:    tlsConfig,
+	}
+
+	// secure.yaml values take precedence over the ones in clouds.yaml
+	for _, file := range []string{cloudsPath, path.Join(path.Dir(cloudsPath), "secure.yaml")} {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var parsed cloudsFile
+		if err := yaml.Unmarshal(content, &parsed); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		cloud := parsed.Clouds[cloudName]
+		cloudConfig.AuthType = coalesce(cloud.AuthType, cloudConfig.AuthType)
+		cloudConfig.OIDC = mergeOIDCClientCredentials(cloud.Auth.OIDCClientCredentials, cloudConfig.OIDC)
+		cloudConfig.BlockStorageVersion = coalesce(cloud.VolumeAPIVersion, cloudConfig.BlockStorageVersion)
+		cloudConfig.AccessKey = coalesce(cloud.Auth.AccessKey, cloudConfig.AccessKey)
+		cloudConfig.SecretKey = coalesce(cloud.Auth.SecretKey, cloudConfig.SecretKey)
+	}
+
+	return cloudConfig, nil
+}
+
+func cloudConfigFromEnv() (*CloudConfig, error) {
 	level.Debug(logger).Log("message", "Parsing environment variables")
-	opts, err := openstack.AuthOptionsFromEnv()
+	authType := os.Getenv("OS_AUTH_TYPE")
+
+	var authOptions gophercloud.AuthOptions
+	if authType == oidcClientCredentialsAuthType || os.Getenv("OS_TOKEN") != "" {
+		// gophercloud insists on user credentials, which these auth types do not have
+		authOptions = gophercloud.AuthOptions{
+			IdentityEndpoint: os.Getenv("OS_AUTH_URL"),
+			TokenID:          os.Getenv("OS_TOKEN"),
+			TenantID:         coalesce(os.Getenv("OS_PROJECT_ID"), os.Getenv("OS_TENANT_ID")),
+			TenantName:       coalesce(os.Getenv("OS_PROJECT_NAME"), os.Getenv("OS_TENANT_NAME")),
+			DomainID:         coalesce(os.Getenv("OS_PROJECT_DOMAIN_ID"), os.Getenv("OS_DOMAIN_ID")),
+			DomainName:       coalesce(os.Getenv("OS_PROJECT_DOMAIN_NAME"), os.Getenv("OS_DOMAIN_NAME")),
+		}
+	} else {
+		var err error
+		authOptions, err = openstack.AuthOptionsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &CloudConfig{
+		AuthType:    authType,
+		AuthOptions: authOptions,
+		OIDC: OIDCClientCredentials{
+			IdentityProvider:    os.Getenv("OS_IDENTITY_PROVIDER"),
+			Protocol:            os.Getenv("OS_PROTOCOL"),
+			ClientID:            os.Getenv("OS_CLIENT_ID"),
+			ClientSecret:        os.Getenv("OS_CLIENT_SECRET"),
+			Scope:               os.Getenv("OS_OPENID_SCOPE"),
+			AccessTokenEndpoint: os.Getenv("OS_ACCESS_TOKEN_ENDPOINT"),
+			DiscoveryEndpoint:   os.Getenv("OS_DISCOVERY_ENDPOINT"),
+		},
+		EndpointOpts: gophercloud.EndpointOpts{
+			Region:       os.Getenv("OS_REGION_NAME"),
+			Availability: availabilityFromInterface(os.Getenv("OS_INTERFACE")),
+		},
+		BlockStorageVersion: os.Getenv("OS_BLOCKSTORAGE_V"),
+		AccessKey:           os.Getenv("OS_ACCESS_KEY"),
+		SecretKey:           os.Getenv("OS_SECRET_KEY"),
+	}, nil
+}
+
+// findCloudsFile returns the first existing clouds.yaml, using the same search
+// order as python-openstackclient
+func findCloudsFile() (string, error) {
+	if file := os.Getenv("OS_CLIENT_CONFIG_FILE"); file != "" {
+		return file, nil
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	userConfig, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	locations := []string{
+		path.Join(cwd, "clouds.yaml"),
+		path.Join(userConfig, "openstack", "clouds.yaml"),
+		path.Join("/etc", "openstack", "clouds.yaml"),
+	}
+	for _, location := range locations {
+		if _, err := os.Stat(location); err == nil {
+			return location, nil
+		}
+	}
+	return "", fmt.Errorf("clouds.yaml not found, search locations were: %v", locations)
+}
+
+func availabilityFromInterface(endpointType string) gophercloud.Availability {
+	switch endpointType {
+	case "internal", "internalURL":
+		return gophercloud.AvailabilityInternal
+	case "admin", "adminURL":
+		return gophercloud.AvailabilityAdmin
+	default:
+		return gophercloud.AvailabilityPublic
+	}
+}
+
+func mergeOIDCClientCredentials(override, options OIDCClientCredentials) OIDCClientCredentials {
+	return OIDCClientCredentials{
+		IdentityProvider:    coalesce(override.IdentityProvider, options.IdentityProvider),
+		Protocol:            coalesce(override.Protocol, options.Protocol),
+		ClientID:            coalesce(override.ClientID, options.ClientID),
+		ClientSecret:        coalesce(override.ClientSecret, options.ClientSecret),
+		Scope:               coalesce(override.Scope, options.Scope),
+		AccessTokenEndpoint: coalesce(override.AccessTokenEndpoint, options.AccessTokenEndpoint),
+		DiscoveryEndpoint:   coalesce(override.DiscoveryEndpoint, options.DiscoveryEndpoint),
+	}
+}
+
+func coalesce(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func authenticateOpenStack(ctx context.Context, cloudConfig *CloudConfig) (*gophercloud.ProviderClient, error) {
+	level.Debug(logger).Log("message", "Authenticating to OpenStack API", "cloud", cloudConfig.Name)
+
+	if cloudConfig.AuthType == oidcClientCredentialsAuthType {
+		providerClient, err := authenticateOIDCClientCredentials(ctx, cloudConfig)
+		if err != nil {
+			return nil, err
+		}
+		// The unscoped token is short lived, so reauthentication starts
+		// again from the client credentials
+		providerClient.ReauthFunc = func(ctx context.Context) error {
+			tac, err := authenticateOIDCClientCredentials(ctx, cloudConfig)
+			if err != nil {
+				return err
+			}
+			providerClient.CopyTokenFrom(tac)
+			return nil
+		}
+		return providerClient, nil
+	}
+
+	// Let gophercloud reauthenticate when a request is rejected with a 401.
+	// A pre-issued token cannot be renewed.
+	authOptions := cloudConfig.AuthOptions
+	authOptions.AllowReauth = authOptions.TokenID == ""
+
+	providerClient, err := config.NewProviderClient(ctx, authOptions, config.WithTLSConfig(cloudConfig.TLSConfig))
+	if err != nil {
+		return nil, err
+	}
+	return providerClient, nil
+}
+
+// authenticateOIDCClientCredentials gets an access token from the OIDC
+// provider, exchanges it for an unscoped Keystone token through the federation
+// API and finally scopes the token to the project
+func authenticateOIDCClientCredentials(ctx context.Context, cloudConfig *CloudConfig) (*gophercloud.ProviderClient, error) {
+	providerClient, err := openstack.NewClient(cloudConfig.AuthOptions.IdentityEndpoint)
 	if err != nil {
-		level.Error(logger).Log("message", "Failed to parse environment variables", "err", err)
+		return nil, err
+	}
+	if cloudConfig.TLSConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cloudConfig.TLSConfig
+		providerClient.HTTPClient.Transport = transport
 	}
 
-	// Authenticate with OpenStack
-	level.Debug(logger).Log("message", "Authenticating to OpenStack API")
-	providerClient, err := openstack.AuthenticatedClient(ctx, opts)
+	accessToken, err := oidcAccessToken(ctx, &providerClient.HTTPClient, cloudConfig.OIDC)
 	if err != nil {
-		level.Error(logger).Log("message", "Failed to authenticate to OpenStack API", "err", err)
+		return nil, err
+	}
+
+	identityClient, err := openstack.NewIdentityV3(providerClient, gophercloud.EndpointOpts{})
+	if err != nil {
+		return nil, err
+	}
+	federationURL := identityClient.ServiceURL("OS-FEDERATION", "identity_providers", cloudConfig.OIDC.IdentityProvider, "protocols", cloudConfig.OIDC.Protocol, "auth")
+	resp, err := providerClient.Request(ctx, http.MethodPost, federationURL, &gophercloud.RequestOpts{
+		MoreHeaders: map[string]string{"Authorization": "Bearer " + accessToken},
+		OkCodes:     []int{201},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange the OIDC access token: %w", err)
+	}
+	unscopedToken := resp.Header.Get("X-Subject-Token")
+
+	authOptions := gophercloud.AuthOptions{
+		IdentityEndpoint: cloudConfig.AuthOptions.IdentityEndpoint,
+		TokenID:          unscopedToken,
+		Scope: &gophercloud.AuthScope{
+			ProjectID:   cloudConfig.AuthOptions.TenantID,
+			ProjectName: cloudConfig.AuthOptions.TenantName,
+			DomainID:    cloudConfig.AuthOptions.DomainID,
+			DomainName:  cloudConfig.AuthOptions.DomainName,
+		},
+	}
+	if err := openstack.Authenticate(ctx, providerClient, authOptions); err != nil {
+		return nil, err
 	}
 	return providerClient, nil
 }
+
+// oidcAccessToken runs the OAuth 2.0 client credentials grant against the
+// token endpoint of the OIDC provider
+func oidcAccessToken(ctx context.Context, httpClient *http.Client, options OIDCClientCredentials) (string, error) {
+	tokenEndpoint := options.AccessTokenEndpoint
+	if tokenEndpoint == "" {
+		if options.DiscoveryEndpoint == "" {
+			return "", fmt.Errorf("either access_token_endpoint or discovery_endpoint is required")
+		}
+		var discovery struct {
+			TokenEndpoint string `json:"token_endpoint"`
+		}
+		if err := getJSON(ctx, httpClient, options.DiscoveryEndpoint, &discovery); err != nil {
+			return "", fmt.Errorf("failed to query the OIDC discovery endpoint: %w", err)
+		}
+		tokenEndpoint = discovery.TokenEndpoint
+	}
+
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"scope":      {coalesce(options.Scope, "openid")},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(options.ClientID), url.QueryEscape(options.ClientSecret))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("OIDC token endpoint returned %s", resp.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+func getJSON(ctx context.Context, httpClient *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// credentialExpiry returns when the credentials of the target stop working.
+// That is the expiry of the application credential or of the pre-issued
+// token, other credentials are not known to expire and return a zero time.
+func credentialExpiry(ctx context.Context, providerClient *gophercloud.ProviderClient, cloudConfig *CloudConfig) (time.Time, error) {
+	result, ok := providerClient.GetAuthResult().(interface {
+		ExtractToken() (*tokens.Token, error)
+		ExtractUser() (*tokens.User, error)
+		ExtractInto(v any) error
+	})
+	if !ok {
+		return time.Time{}, nil
+	}
+
+	if cloudConfig.AuthOptions.TokenID != "" {
+		token, err := result.ExtractToken()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return token.ExpiresAt, nil
+	}
+
+	if cloudConfig.AuthOptions.ApplicationCredentialID == "" && cloudConfig.AuthOptions.ApplicationCredentialName == "" {
+		return time.Time{}, nil
+	}
+
+	// The token only references the application credential, the expiry has
+	// to be looked up
+	var token struct {
+		ApplicationCredential struct {
+			ID string `json:"id"`
+		} `json:"application_credential"`
+	}
+	if err := result.ExtractInto(&token); err != nil {
+		return time.Time{}, err
+	}
+	user, err := result.ExtractUser()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	identityClient, err := openstack.NewIdentityV3(providerClient, gophercloud.EndpointOpts{})
+	if err != nil {
+		return time.Time{}, err
+	}
+	applicationCredential, err := applicationcredentials.Get(ctx, identityClient, user.ID, token.ApplicationCredential.ID).Extract()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return applicationCredential.ExpiresAt, nil
+}
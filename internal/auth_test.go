@@ -0,0 +1,414 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+func TestMain(m *testing.M) {
+	SetLogger(log.NewNopLogger())
+	os.Exit(m.Run())
+}
+
+var (
+	fakeTokenExpiry         = time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	fakeApplicationExpiry   = time.Date(2031, 6, 7, 8, 9, 10, 0, time.UTC)
+	fakeApplicationCredID   = "appcred"
+	fakeOIDCClientID        = "exporter"
+	fakeOIDCClientSecret    = "s3cr3t"
+	fakeIdentityProvider    = "idp"
+	fakeFederationProtocol  = "openid"
+	fakeProjectID           = "project"
+	fakeUserID              = "user"
+	fakeApplicationCredPath = "/v3/users/" + fakeUserID + "/application_credentials/" + fakeApplicationCredID
+)
+
+// fakeKeystone serves the parts of the Keystone API and of an OIDC provider
+// used for authentication. Tokens are numbered in the order they are issued.
+type fakeKeystone struct {
+	*httptest.Server
+
+	mu             sync.Mutex
+	tokens         int
+	accessTokens   int
+	unscopedTokens int
+	// methods are the auth methods of the token requests
+	methods []string
+	// scopes are the projects the token requests were scoped to
+	scopes []string
+}
+
+func newFakeKeystone(t *testing.T) *fakeKeystone {
+	keystone := &fakeKeystone{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v3/auth/tokens", keystone.createToken)
+	mux.HandleFunc("GET /v3/auth/tokens", keystone.validateToken)
+	mux.HandleFunc("POST /v3/OS-FEDERATION/identity_providers/"+fakeIdentityProvider+"/protocols/"+fakeFederationProtocol+"/auth", keystone.federatedAuth)
+	mux.HandleFunc("GET "+fakeApplicationCredPath, keystone.getApplicationCredential)
+	mux.HandleFunc("GET /oidc/.well-known/openid-configuration", keystone.discovery)
+	mux.HandleFunc("POST /oidc/token", keystone.oidcToken)
+	keystone.Server = httptest.NewServer(mux)
+	t.Cleanup(keystone.Close)
+	return keystone
+}
+
+func (k *fakeKeystone) createToken(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Auth struct {
+			Identity struct {
+				Methods []string `json:"methods"`
+				Token   struct {
+					ID string `json:"id"`
+				} `json:"token"`
+			} `json:"identity"`
+			Scope struct {
+				Project struct {
+					ID string `json:"id"`
+				} `json:"project"`
+			} `json:"scope"`
+		} `json:"auth"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	methods := strings.Join(request.Auth.Identity.Methods, ",")
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	// Only the latest unscoped token of the federation API is valid
+	if methods == "token" && strings.HasPrefix(request.Auth.Identity.Token.ID, "unscoped") &&
+		request.Auth.Identity.Token.ID != fmt.Sprintf("unscoped-%d", k.unscopedTokens) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	k.tokens++
+	k.methods = append(k.methods, methods)
+	k.scopes = append(k.scopes, request.Auth.Scope.Project.ID)
+
+	w.Header().Set("X-Subject-Token", fmt.Sprintf("token-%d", k.tokens))
+	k.writeToken(w, http.StatusCreated, request.Auth.Identity.Methods)
+}
+
+// validateToken serves the lookup of a pre-issued token, which gophercloud
+// does instead of creating a new one
+func (k *fakeKeystone) validateToken(w http.ResponseWriter, r *http.Request) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.methods = append(k.methods, "token")
+	k.scopes = append(k.scopes, "")
+
+	w.Header().Set("X-Subject-Token", r.Header.Get("X-Subject-Token"))
+	k.writeToken(w, http.StatusOK, []string{"token"})
+}
+
+func (k *fakeKeystone) writeToken(w http.ResponseWriter, code int, methods []string) {
+	token := map[string]any{
+		"methods":    methods,
+		"expires_at": fakeTokenExpiry.Format(time.RFC3339),
+		"user":       map[string]any{"id": fakeUserID, "name": fakeUserID},
+		"project":    map[string]any{"id": fakeProjectID, "name": fakeProjectID},
+		"catalog": []any{map[string]any{
+			"type": "identity",
+			"name": "keystone",
+			"endpoints": []any{map[string]any{
+				"interface": "public",
+				"region":    "RegionOne",
+				"url":       k.URL + "/v3/",
+			}},
+		}},
+	}
+	if strings.Join(methods, ",") == "application_credential" {
+		token["application_credential"] = map[string]any{"id": fakeApplicationCredID}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"token": token})
+}
+
+func (k *fakeKeystone) federatedAuth(w http.ResponseWriter, r *http.Request) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if r.Header.Get("Authorization") != fmt.Sprintf("Bearer access-%d", k.accessTokens) {
+		http.Error(w, "invalid access token", http.StatusUnauthorized)
+		return
+	}
+	k.unscopedTokens++
+	w.Header().Set("X-Subject-Token", fmt.Sprintf("unscoped-%d", k.unscopedTokens))
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(`{"token": {}}`))
+}
+
+func (k *fakeKeystone) getApplicationCredential(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"application_credential": map[string]any{
+		"id":         fakeApplicationCredID,
+		"expires_at": fakeApplicationExpiry.Format("2006-01-02T15:04:05.999999"),
+	}})
+}
+
+func (k *fakeKeystone) discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"token_endpoint": k.URL + "/oidc/token"})
+}
+
+func (k *fakeKeystone) oidcToken(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	if clientID != fakeOIDCClientID || clientSecret != fakeOIDCClientSecret {
+		http.Error(w, "invalid client", http.StatusUnauthorized)
+		return
+	}
+	if r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "openid" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.accessTokens++
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"access_token": fmt.Sprintf("access-%d", k.accessTokens)})
+}
+
+// writeCloudsFile writes a clouds.yaml with a single cloud and points
+// OS_CLIENT_CONFIG_FILE to it
+func writeCloudsFile(t *testing.T, cloud string) {
+	path := filepath.Join(t.TempDir(), "clouds.yaml")
+	if err := os.WriteFile(path, []byte("clouds:\n  test:\n"+cloud), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OS_CLIENT_CONFIG_FILE", path)
+}
+
+func TestAuthenticateOIDCClientCredentials(t *testing.T) {
+	keystone := newFakeKeystone(t)
+	writeCloudsFile(t, fmt.Sprintf(`    auth_type: v3oidcclientcredentials
+    auth:
+      auth_url: %s/v3/
+      project_id: %s
+      identity_provider: %s
+      protocol: %s
+      client_id: %s
+      client_secret: %s
+      discovery_endpoint: %s/oidc/.well-known/openid-configuration
+`, keystone.URL, fakeProjectID, fakeIdentityProvider, fakeFederationProtocol, fakeOIDCClientID, fakeOIDCClientSecret, keystone.URL))
+
+	cloudConfig, err := LoadCloudConfig("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cloudConfig.AuthType != oidcClientCredentialsAuthType {
+		t.Fatalf("expected auth type %q, got %q", oidcClientCredentialsAuthType, cloudConfig.AuthType)
+	}
+
+	providerClient, err := authenticateOpenStack(context.Background(), cloudConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if providerClient.Token() != "token-1" {
+		t.Errorf("expected the scoped token token-1, got %q", providerClient.Token())
+	}
+	if keystone.methods[0] != "token" || keystone.scopes[0] != fakeProjectID {
+		t.Errorf("expected the unscoped token to be scoped to %q, got methods %q and scope %q", fakeProjectID, keystone.methods[0], keystone.scopes[0])
+	}
+
+	// The unscoped token expires quickly, so reauthentication has to start
+	// again from the client credentials
+	if err := providerClient.Reauthenticate(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if providerClient.Token() != "token-2" {
+		t.Errorf("expected the renewed token token-2, got %q", providerClient.Token())
+	}
+	if keystone.accessTokens != 2 || keystone.unscopedTokens != 2 {
+		t.Errorf("expected 2 access and unscoped tokens, got %d and %d", keystone.accessTokens, keystone.unscopedTokens)
+	}
+
+	expiry, err := credentialExpiry(context.Background(), providerClient, cloudConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expiry.IsZero() {
+		t.Errorf("expected no credential expiry, got %s", expiry)
+	}
+}
+
+func TestOIDCAccessToken(t *testing.T) {
+	keystone := newFakeKeystone(t)
+
+	tests := []struct {
+		name    string
+		options OIDCClientCredentials
+		err     string
+	}{
+		{
+			name: "token endpoint",
+			options: OIDCClientCredentials{
+				ClientID:            fakeOIDCClientID,
+				ClientSecret:        fakeOIDCClientSecret,
+				AccessTokenEndpoint: keystone.URL + "/oidc/token",
+			},
+		},
+		{
+			name: "discovery endpoint",
+			options: OIDCClientCredentials{
+				ClientID:          fakeOIDCClientID,
+				ClientSecret:      fakeOIDCClientSecret,
+				DiscoveryEndpoint: keystone.URL + "/oidc/.well-known/openid-configuration",
+			},
+		},
+		{
+			name:    "no endpoint",
+			options: OIDCClientCredentials{ClientID: fakeOIDCClientID, ClientSecret: fakeOIDCClientSecret},
+			err:     "either access_token_endpoint or discovery_endpoint is required",
+		},
+		{
+			name: "failing discovery",
+			options: OIDCClientCredentials{
+				ClientID:          fakeOIDCClientID,
+				ClientSecret:      fakeOIDCClientSecret,
+				DiscoveryEndpoint: keystone.URL + "/oidc/missing",
+			},
+			err: "failed to query the OIDC discovery endpoint",
+		},
+		{
+			name: "invalid client",
+			options: OIDCClientCredentials{
+				ClientID:            fakeOIDCClientID,
+				ClientSecret:        "wrong",
+				AccessTokenEndpoint: keystone.URL + "/oidc/token",
+			},
+			err: "401 Unauthorized",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := keystone.accessTokens
+			accessToken, err := oidcAccessToken(context.Background(), http.DefaultClient, test.options)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expected := fmt.Sprintf("access-%d", before+1); accessToken != expected {
+				t.Errorf("expected access token %q, got %q", expected, accessToken)
+			}
+		})
+	}
+}
+
+func TestCredentialExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		methods string
+		token   string
+		reauth  bool
+		expiry  time.Time
+	}{
+		{
+			name: "application credential",
+			env: map[string]string{
+				"OS_APPLICATION_CREDENTIAL_ID":     fakeApplicationCredID,
+				"OS_APPLICATION_CREDENTIAL_SECRET": "secret",
+			},
+			methods: "application_credential",
+			token:   "token-1",
+			reauth:  true,
+			expiry:  fakeApplicationExpiry,
+		},
+		{
+			name: "token",
+			env: map[string]string{
+				"OS_TOKEN":      "pre-issued",
+				"OS_PROJECT_ID": fakeProjectID,
+			},
+			methods: "token",
+			token:   "pre-issued",
+			expiry:  fakeTokenExpiry,
+		},
+		{
+			name: "password",
+			env: map[string]string{
+				"OS_USERNAME":    fakeUserID,
+				"OS_PASSWORD":    "password",
+				"OS_PROJECT_ID":  fakeProjectID,
+				"OS_DOMAIN_NAME": "Default",
+			},
+			methods: "password",
+			token:   "token-1",
+			reauth:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keystone := newFakeKeystone(t)
+			t.Setenv("OS_AUTH_URL", keystone.URL+"/v3/")
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			cloudConfig, err := LoadCloudConfig("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := &Target{Name: test.name, CloudConfig: cloudConfig, lastCollections: make(map[string]time.Time)}
+
+			providerClient, err := target.ProviderClient(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if providerClient.Token() != test.token {
+				t.Errorf("expected token %q, got %q", test.token, providerClient.Token())
+			}
+			if keystone.methods[0] != test.methods {
+				t.Errorf("expected auth methods %q, got %q", test.methods, keystone.methods[0])
+			}
+			if target.ProjectID() != fakeProjectID {
+				t.Errorf("expected project ID %q, got %q", fakeProjectID, target.ProjectID())
+			}
+
+			authStats := target.getAuthStats()
+			if !authStats.tokenExpiry.Equal(fakeTokenExpiry) {
+				t.Errorf("expected token expiry %s, got %s", fakeTokenExpiry, authStats.tokenExpiry)
+			}
+			if !authStats.credentialExpiry.Equal(test.expiry) {
+				t.Errorf("expected credential expiry %s, got %s", test.expiry, authStats.credentialExpiry)
+			}
+
+			// A pre-issued token cannot be renewed
+			if (providerClient.ReauthFunc != nil) != test.reauth {
+				t.Fatalf("expected reauthentication %t", test.reauth)
+			}
+			if !test.reauth {
+				return
+			}
+			if err := providerClient.Reauthenticate(context.Background(), ""); err != nil {
+				t.Fatal(err)
+			}
+			if providerClient.Token() != "token-2" {
+				t.Errorf("expected the renewed token token-2, got %q", providerClient.Token())
+			}
+			if authStats := target.getAuthStats(); authStats.successes != 2 || authStats.failures != 0 {
+				t.Errorf("expected 2 successful authentications, got %d successes and %d failures", authStats.successes, authStats.failures)
+			}
+		})
+	}
+}
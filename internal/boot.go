@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// bootTimeBuckets are the upper bounds of the boot time histogram in seconds
+var bootTimeBuckets = []float64{5, 10, 15, 30, 45, 60, 90, 120, 180, 300, 600, 1200}
+
+// bootTracker measures how long servers take to build by comparing the server
+// lists of successive collections. A build is seen when a server was in BUILD
+// or was created since the previous collection and is now ACTIVE or ERROR.
+type bootTracker struct {
+	bootDuration *prometheus.Desc
+	buildErrors  *prometheus.Desc
+
+	mu      sync.Mutex
+	targets map[*Target]*targetBoots
+}
+
+type targetBoots struct {
+	lastCollection time.Time
+	statuses       map[string]string
+	durations      map[bootKey]*bootHistogram
+	errors         map[bootKey]uint64
+}
+
+type bootKey struct {
+	flavor           string
+	availabilityZone string
+}
+
+type bootHistogram struct {
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+}
+
+func newBootTracker() *bootTracker {
+	return &bootTracker{
+		bootDuration: prometheus.NewDesc("openstack_server_boot_duration_seconds",
+			"The time servers took from their creation to ACTIVE in seconds",
+			targetLabels("flavor", "availability_zone"), nil,
+		),
+		buildErrors: prometheus.NewDesc("openstack_server_build_errors_total",
+			"The number of server builds which ended in ERROR",
+			targetLabels("flavor", "availability_zone"), nil,
+		),
+		targets: make(map[*Target]*targetBoots),
+	}
+}
+
+// build is a server build which finished since the previous collection
+type build struct {
+	server servers.Server
+	failed bool
+}
+
+// update records the builds which finished since the previous collection of
+// the target and sends the histograms and counters of all builds seen.
+// Overlapping updates of a target, like concurrent scrapes, must not count a
+// build twice, so the builds are claimed and the statuses replaced under a
+// single lock. The flavor names of the claimed builds are resolved afterwards,
+// as they might be requested from the API.
+func (t *bootTracker) update(target *Target, serverList []servers.Server, flavorName func(servers.Server) string, now time.Time, ch chan<- prometheus.Metric) {
+	statuses := make(map[string]string, len(serverList))
+	for _, server := range serverList {
+		statuses[server.ID] = server.Status
+	}
+
+	t.mu.Lock()
+	boots, ok := t.targets[target]
+	if !ok {
+		boots = &targetBoots{
+			durations: make(map[bootKey]*bootHistogram),
+			errors:    make(map[bootKey]uint64),
+		}
+		t.targets[target] = boots
+	}
+	var builds []build
+	// An update which started before the latest one has older servers,
+	// which would count the builds seen since again
+	if !now.Before(boots.lastCollection) {
+		builds = boots.claimBuilds(serverList)
+		boots.statuses = statuses
+		boots.lastCollection = now
+	}
+	t.mu.Unlock()
+
+	keys := make([]bootKey, len(builds))
+	for i, build := range builds {
+		keys[i] = bootKey{flavor: flavorName(build.server), availabilityZone: build.server.AvailabilityZone}
+	}
+
+	t.mu.Lock()
+	for i, build := range builds {
+		if build.failed {
+			boots.errors[keys[i]]++
+		} else {
+			boots.observe(keys[i], build.server.LaunchedAt.Sub(build.server.Created).Seconds())
+		}
+	}
+
+	// The metrics read the buckets when they are gathered, after the lock
+	// was released
+	metrics := make([]prometheus.Metric, 0, len(boots.durations)+len(boots.errors))
+	for key, histogram := range boots.durations {
+		buckets := make(map[float64]uint64, len(histogram.buckets))
+		for bucket, count := range histogram.buckets {
+			buckets[bucket] = count
+		}
+		metrics = append(metrics, prometheus.MustNewConstHistogram(t.bootDuration, histogram.count, histogram.sum, buckets, target.labelValues(key.flavor, key.availabilityZone)...))
+	}
+	for key, count := range boots.errors {
+		metrics = append(metrics, prometheus.MustNewConstMetric(t.buildErrors, prometheus.CounterValue, float64(count), target.labelValues(key.flavor, key.availabilityZone)...))
+	}
+	t.mu.Unlock()
+
+	for _, metric := range metrics {
+		ch <- metric
+	}
+}
+
+// claimBuilds returns the servers of the list which finished building since
+// the previous collection
+func (b *targetBoots) claimBuilds(serverList []servers.Server) []build {
+	var builds []build
+	for _, server := range serverList {
+		if server.Status != "ACTIVE" && server.Status != "ERROR" {
+			continue
+		}
+
+		previousStatus, seen := b.statuses[server.ID]
+		building := previousStatus == "BUILD"
+		// A server can be built in between two collections, which is
+		// unknown before the first one. Nova reports the creation time in
+		// seconds.
+		if !seen && b.statuses != nil && !server.Created.Before(b.lastCollection.Truncate(time.Second)) {
+			building = true
+		}
+		if !building {
+			continue
+		}
+
+		if server.Status == "ERROR" {
+			builds = append(builds, build{server: server, failed: true})
+			continue
+		}
+		// The launch time is the end of the build, without it the boot
+		// time is unknown
+		if server.LaunchedAt.IsZero() {
+			continue
+		}
+		builds = append(builds, build{server: server})
+	}
+	return builds
+}
+
+func (b *targetBoots) observe(key bootKey, seconds float64) {
+	histogram, ok := b.durations[key]
+	if !ok {
+		histogram = &bootHistogram{buckets: make(map[float64]uint64, len(bootTimeBuckets))}
+		for _, bucket := range bootTimeBuckets {
+			histogram.buckets[bucket] = 0
+		}
+		b.durations[key] = histogram
+	}
+
+	histogram.count++
+	histogram.sum += seconds
+	// The buckets are cumulative
+	upperBounds := sort.SearchFloat64s(bootTimeBuckets, seconds)
+	for _, bucket := range bootTimeBuckets[upperBounds:] {
+		histogram.buckets[bucket]++
+	}
+}
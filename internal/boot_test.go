@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestBootTrackerUpdate(t *testing.T) {
+	tracker := newBootTracker()
+	target := newTestTarget("a")
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	server := func(id, status string, created, launched time.Duration) servers.Server {
+		s := servers.Server{
+			ID:               id,
+			Status:           status,
+			Created:          start.Add(created),
+			AvailabilityZone: "az1",
+			Flavor:           map[string]any{"id": "f1"},
+		}
+		if launched != 0 {
+			s.LaunchedAt = start.Add(launched)
+		}
+		return s
+	}
+
+	var resolved []string
+	flavorName := func(server servers.Server) string {
+		resolved = append(resolved, server.ID)
+		return "m1.small"
+	}
+
+	steps := []struct {
+		name      string
+		after     time.Duration
+		servers   []servers.Server
+		resolved  []string
+		durations map[string]float64
+		errors    map[string]float64
+	}{
+		{
+			name: "first collection",
+			servers: []servers.Server{
+				server("s1", "BUILD", -10*time.Second, 0),
+				server("s2", "ACTIVE", -time.Hour, -time.Hour+30*time.Second),
+			},
+			durations: map[string]float64{},
+			errors:    map[string]float64{},
+		},
+		{
+			name:  "builds finished",
+			after: time.Minute,
+			servers: []servers.Server{
+				// Seen in BUILD before
+				server("s1", "ACTIVE", -10*time.Second, 35*time.Second),
+				server("s2", "ACTIVE", -time.Hour, -time.Hour+30*time.Second),
+				// Created in between the collections
+				server("s3", "ACTIVE", 0, 20*time.Second),
+				server("s4", "ERROR", 10*time.Second, 0),
+				// Without a launch time the boot time is unknown
+				server("s5", "ACTIVE", 20*time.Second, 0),
+				// Created before the previous collection, but not seen in it
+				server("s6", "ACTIVE", -time.Minute, -30*time.Second),
+				server("s7", "BUILD", 30*time.Second, 0),
+			},
+			resolved:  []string{"s1", "s3", "s4"},
+			durations: map[string]float64{"availability_zone=az1,flavor=m1.small": 2},
+			errors:    map[string]float64{"availability_zone=az1,flavor=m1.small": 1},
+		},
+		{
+			name:  "nothing new",
+			after: 2 * time.Minute,
+			servers: []servers.Server{
+				server("s1", "ACTIVE", -10*time.Second, 35*time.Second),
+				server("s3", "ACTIVE", 0, 20*time.Second),
+				server("s4", "ERROR", 10*time.Second, 0),
+				server("s7", "BUILD", 30*time.Second, 0),
+			},
+			durations: map[string]float64{"availability_zone=az1,flavor=m1.small": 2},
+			errors:    map[string]float64{"availability_zone=az1,flavor=m1.small": 1},
+		},
+	}
+
+	for _, step := range steps {
+		resolved = nil
+		metrics := make(chan prometheus.Metric, 100)
+		tracker.update(target, step.servers, flavorName, start.Add(step.after), metrics)
+		close(metrics)
+
+		var all []prometheus.Metric
+		for metric := range metrics {
+			all = append(all, metric)
+		}
+		replay := func(ch chan<- prometheus.Metric) {
+			for _, metric := range all {
+				ch <- metric
+			}
+		}
+
+		if !reflect.DeepEqual(resolved, step.resolved) {
+			t.Errorf("%s: expected the flavors of %v to be resolved, got %v", step.name, step.resolved, resolved)
+		}
+		if durations := collectSamples(t, tracker.bootDuration, replay); !reflect.DeepEqual(durations, step.durations) {
+			t.Errorf("%s: expected boot durations %v, got %v", step.name, step.durations, durations)
+		}
+		if errors := collectSamples(t, tracker.buildErrors, replay); !reflect.DeepEqual(errors, step.errors) {
+			t.Errorf("%s: expected build errors %v, got %v", step.name, step.errors, errors)
+		}
+	}
+}
+
+func TestBootTrackerBuckets(t *testing.T) {
+	tracker := newBootTracker()
+	target := newTestTarget("a")
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	flavorName := func(servers.Server) string { return "m1.small" }
+
+	discard := make(chan prometheus.Metric, 100)
+	tracker.update(target, []servers.Server{{ID: "s1", Status: "BUILD", Created: start}}, flavorName, start, discard)
+
+	ch := make(chan prometheus.Metric, 100)
+	tracker.update(target, []servers.Server{{ID: "s1", Status: "ACTIVE", Created: start, LaunchedAt: start.Add(40 * time.Second)}}, flavorName, start.Add(time.Minute), ch)
+	close(ch)
+
+	metric := <-ch
+	// A later build must not change the histogram which was already sent
+	tracker.update(target, []servers.Server{
+		{ID: "s1", Status: "ACTIVE", Created: start, LaunchedAt: start.Add(40 * time.Second)},
+		{ID: "s2", Status: "ACTIVE", Created: start.Add(time.Minute), LaunchedAt: start.Add(time.Minute + 3*time.Second)},
+	}, flavorName, start.Add(2*time.Minute), discard)
+
+	var m dto.Metric
+	if err := metric.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	histogram := m.GetHistogram()
+	if histogram.GetSampleCount() != 1 || histogram.GetSampleSum() != 40 {
+		t.Fatalf("expected a single build of 40s, got %d builds of %vs", histogram.GetSampleCount(), histogram.GetSampleSum())
+	}
+	for _, bucket := range histogram.GetBucket() {
+		expected := uint64(0)
+		if bucket.GetUpperBound() >= 40 {
+			expected = 1
+		}
+		if bucket.GetCumulativeCount() != expected {
+			t.Errorf("expected %d builds up to %vs, got %d", expected, bucket.GetUpperBound(), bucket.GetCumulativeCount())
+		}
+	}
+}
+
+func TestBootTrackerConcurrentUpdates(t *testing.T) {
+	tracker := newBootTracker()
+	target := newTestTarget("a")
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	discard := func() chan prometheus.Metric { return make(chan prometheus.Metric, 100) }
+
+	building := []servers.Server{{ID: "s1", Status: "BUILD", Created: start}}
+	active := []servers.Server{{ID: "s1", Status: "ACTIVE", Created: start, LaunchedAt: start.Add(40 * time.Second)}}
+	tracker.update(target, building, func(servers.Server) string { return "m1.small" }, start, discard())
+
+	// The first update blocks while resolving the flavor, like on a slow
+	// API, while a second scrape of the same target runs
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	flavorName := func(servers.Server) string {
+		once.Do(func() { close(entered) })
+		<-release
+		return "m1.small"
+	}
+
+	first := make(chan struct{})
+	go func() {
+		tracker.update(target, active, flavorName, start.Add(time.Minute), discard())
+		close(first)
+	}()
+	<-entered
+
+	second := make(chan struct{})
+	go func() {
+		tracker.update(target, active, flavorName, start.Add(time.Minute+time.Second), discard())
+		close(second)
+	}()
+	select {
+	case <-second:
+	case <-time.After(time.Second):
+		t.Error("the second update waited for the flavor of a build claimed by the first")
+	}
+	close(release)
+	<-first
+	<-second
+
+	samples := collectSamples(t, tracker.bootDuration, func(ch chan<- prometheus.Metric) {
+		tracker.update(target, active, flavorName, start.Add(2*time.Minute), ch)
+	})
+	if expected := map[string]float64{"availability_zone=,flavor=m1.small": 1}; !reflect.DeepEqual(samples, expected) {
+		t.Errorf("expected a single build, got %v", samples)
+	}
+
+	// An update which started before the latest one does not count the
+	// build again
+	tracker.update(target, building, flavorName, start.Add(3*time.Minute), discard())
+	tracker.update(target, active, flavorName, start.Add(4*time.Minute), discard())
+	samples = collectSamples(t, tracker.bootDuration, func(ch chan<- prometheus.Metric) {
+		tracker.update(target, active, flavorName, start.Add(3*time.Minute+time.Second), ch)
+	})
+	if expected := map[string]float64{"availability_zone=,flavor=m1.small": 2}; !reflect.DeepEqual(samples, expected) {
+		t.Errorf("expected two builds, got %v", samples)
+	}
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is implemented by the sub-collectors, each of them exporting the
+// metrics of a single OpenStack service
+type Collector interface {
+	// Update sends the metrics of the target to ch. An error is returned
+	// when the collection failed, even partially. The requests have to be
+	// cancelled once ctx is done.
+	Update(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, ch chan<- prometheus.Metric) error
+}
+
+var (
+	factories              = make(map[string]func() Collector)
+	collectorState         = make(map[string]*bool)
+	collectorServices      = make(map[string]string)
+	initiatedCollectorsMtx = sync.Mutex{}
+	initiatedCollectors    = make(map[string]Collector)
+)
+
+// registerCollector makes a collector available under the given name, with a
+// --collector.<name> flag to enable or disable it. The service is the one
+// reported in openstack_up.
+func registerCollector(name, service string, isDefaultEnabled bool, factory func() Collector) {
+	var helpDefaultState string
+	if isDefaultEnabled {
+		helpDefaultState = "enabled"
+	} else {
+		helpDefaultState = "disabled"
+	}
+
+	flagName := fmt.Sprintf("collector.%s", name)
+	flagHelp := fmt.Sprintf("Enable the %s collector (default: %s).", name, helpDefaultState)
+	defaultValue := fmt.Sprintf("%v", isDefaultEnabled)
+
+	collectorState[name] = kingpin.Flag(flagName, flagHelp).Default(defaultValue).Bool()
+	collectorServices[name] = service
+	factories[name] = factory
+}
+
+// flagValidators check the flags of the collectors which cannot be validated by
+// kingpin itself
+var flagValidators []func() error
+
+// ValidateFlags checks the flags of the collectors, it has to be called after
+// the flags were parsed
+func ValidateFlags() error {
+	for _, validate := range flagValidators {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectorNames returns the names of all registered collectors, sorted so the
+// collectors always run in the same order
+func collectorNames() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isCollectorName(name string) bool {
+	_, ok := factories[name]
+	return ok
+}
+
+// enabledCollectors returns the collectors enabled with the flags, or only the
+// ones named in filters. Filtering on a disabled collector is an error.
+func enabledCollectors(filters ...string) (map[string]Collector, error) {
+	names := filters
+	if len(names) == 0 {
+		for _, name := range collectorNames() {
+			if *collectorState[name] {
+				names = append(names, name)
+			}
+		}
+	}
+
+	initiatedCollectorsMtx.Lock()
+	defer initiatedCollectorsMtx.Unlock()
+
+	collectors := make(map[string]Collector)
+	for _, name := range names {
+		enabled, ok := collectorState[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+		if !*enabled {
+			return nil, fmt.Errorf("disabled collector %q", name)
+		}
+		// Collectors are shared between scrapes, so the ones keeping state
+		// across scrapes keep it when filtering
+		if collector, ok := initiatedCollectors[name]; ok {
+			collectors[name] = collector
+			continue
+		}
+		collector := factories[name]()
+		initiatedCollectors[name] = collector
+		collectors[name] = collector
+	}
+	return collectors, nil
+}
@@ -3,15 +3,248 @@ package internal
 import (
 	"context"
 	"encoding/json"
-	"os"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log/level"
 	"github.com/gophercloud/gophercloud/v2"
 	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/flavors"
 	computeLimits "github.com/gophercloud/gophercloud/v2/openstack/compute/v2/limits"
+	computeQuotasets "github.com/gophercloud/gophercloud/v2/openstack/compute/v2/quotasets"
 	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+var perServerInfo = kingpin.Flag("compute.per-server-info", "Export the details and state of every server, which adds series per server").Default("false").Bool()
+
+var serverLabelKeys = kingpin.Flag("compute.server-label", "Nova metadata key or server tag added as label to the server metrics and the per tag aggregations, can be repeated. Tags are matched as <key>=<value>.").Strings()
+
+func init() {
+	registerCollector("compute", "compute", true, newComputeCollector)
+	flagValidators = append(flagValidators, validateServerLabels)
+}
+
+type computeCollector struct {
+	flavorInfo              *prometheus.Desc
+	flavorRemainingCapacity *prometheus.Desc
+	maxTotalCores           *prometheus.Desc
+	maxTotalInstances       *prometheus.Desc
+	maxTotalRAMSize         *prometheus.Desc
+	perFlavorInstanceCount  *prometheus.Desc
+	perStatusInstanceCount  *prometheus.Desc
+	perTagInstanceCount     *prometheus.Desc
+	perTagInstanceRAM       *prometheus.Desc
+	perTagInstanceVCPUs     *prometheus.Desc
+	quotaUsed               *prometheus.Desc
+	quotaLimit              *prometheus.Desc
+	quotaReserved           *prometheus.Desc
+	serverCreated           *prometheus.Desc
+	serverInfo              *prometheus.Desc
+	serverLocked            *prometheus.Desc
+	serverPowerState        *prometheus.Desc
+	totalCoresUsed          *prometheus.Desc
+	totalInstancesUsed      *prometheus.Desc
+	totalRAMUsed            *prometheus.Desc
+	flavors                 *flavorCache
+	statuses                *statusTracker
+	boots                   *bootTracker
+}
+
+func newComputeCollector() Collector {
+	serverLabels := serverLabelNames()
+	return &computeCollector{
+		flavorInfo: prometheus.NewDesc("openstack_flavor_info",
+			"Information about the flavor",
+			targetLabels("flavor_id", "name", "vcpus", "ram_mb", "disk_gb"), nil,
+		),
+		flavorRemainingCapacity: prometheus.NewDesc("openstack_flavor_remaining_capacity",
+			"The number of instances of the flavor which still fit in the compute quota, constraint is the quota running out first",
+			targetLabels("flavor", "constraint"), nil,
+		),
+		maxTotalCores: prometheus.NewDesc("openstack_max_total_cores",
+			"The limit of cores that can be assigned to instances in the project",
+			targetLabels(), nil,
+		),
+		maxTotalInstances: prometheus.NewDesc("openstack_max_total_instances",
+			"The limit of total instances in the project",
+			targetLabels(), nil,
+		),
+		maxTotalRAMSize: prometheus.NewDesc("openstack_max_total_ram_size",
+			"The limit of RAM that can be assigned to instances in the project",
+			targetLabels(), nil,
+		),
+		perFlavorInstanceCount: prometheus.NewDesc("openstack_per_flavor_instance_count",
+			"Number of instances per flavor",
+			targetLabels("flavor"), nil,
+		),
+		perStatusInstanceCount: prometheus.NewDesc("openstack_per_status_instance_count",
+			"Number of instances per status",
+			targetLabels("status"), nil,
+		),
+		perTagInstanceCount: prometheus.NewDesc("openstack_per_tag_instance_count",
+			"Number of instances per value of the labels set with --compute.server-label",
+			targetLabels(serverLabels...), nil,
+		),
+		perTagInstanceRAM: prometheus.NewDesc("openstack_per_tag_instance_ram",
+			"The RAM of the instances in MiB per value of the labels set with --compute.server-label",
+			targetLabels(serverLabels...), nil,
+		),
+		perTagInstanceVCPUs: prometheus.NewDesc("openstack_per_tag_instance_vcpus",
+			"The vCPUs of the instances per value of the labels set with --compute.server-label",
+			targetLabels(serverLabels...), nil,
+		),
+		quotaUsed: prometheus.NewDesc("openstack_compute_quota_used",
+			"The current usage of the compute resource in the project",
+			targetLabels("resource"), nil,
+		),
+		quotaLimit: prometheus.NewDesc("openstack_compute_quota_limit",
+			"The quota limit of the compute resource in the project, -1 when unlimited",
+			targetLabels("resource"), nil,
+		),
+		quotaReserved: prometheus.NewDesc("openstack_compute_quota_reserved",
+			"The reserved usage of the compute resource in the project",
+			targetLabels("resource"), nil,
+		),
+		serverCreated: prometheus.NewDesc("openstack_server_created_timestamp_seconds",
+			"The time the server was created in seconds since epoch",
+			targetLabels(append([]string{"id"}, serverLabels...)...), nil,
+		),
+		serverInfo: prometheus.NewDesc("openstack_server_info",
+			"Information about the server",
+			targetLabels(append([]string{"id", "name", "flavor", "image", "availability_zone", "key_name"}, serverLabels...)...), nil,
+		),
+		serverLocked: prometheus.NewDesc("openstack_server_locked",
+			"Whether the server is locked",
+			targetLabels(append([]string{"id"}, serverLabels...)...), nil,
+		),
+		serverPowerState: prometheus.NewDesc("openstack_server_power_state",
+			"The power state of the server, 0: NOSTATE, 1: RUNNING, 3: PAUSED, 4: SHUTDOWN, 6: CRASHED, 7: SUSPENDED",
+			targetLabels(append([]string{"id", "task_state", "vm_state"}, serverLabels...)...), nil,
+		),
+		totalCoresUsed: prometheus.NewDesc("openstack_total_cores_used",
+			"The current number of cores used",
+			targetLabels(), nil,
+		),
+		totalInstancesUsed: prometheus.NewDesc("openstack_total_instances_used",
+			"The current number of instances",
+			targetLabels(), nil,
+		),
+		totalRAMUsed: prometheus.NewDesc("openstack_total_ram_used",
+			"The current number RAM used",
+			targetLabels(), nil,
+		),
+		flavors:  newFlavorCache(),
+		statuses: newStatusTracker("server"),
+		boots:    newBootTracker(),
+	}
+}
+
+func (collector *computeCollector) Update(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, ch chan<- prometheus.Metric) error {
+	labels := target.labelValues()
+
+	// Keep going after a failed request, so a partial outage still returns
+	// the metrics which could be fetched
+	var errs []error
+
+	flavorList, err := getAllFlavors(ctx, providerClient, target.CloudConfig)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get flavors: %w", err))
+	} else {
+		collector.flavors.update(target, flavorList)
+		for _, flavor := range flavorList {
+			collector.collectFlavor(target, flavor, ch)
+		}
+	}
+
+	computeLimits, err := target.Provider().ComputeLimits(ctx, providerClient, target)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get compute limits: %w", err))
+	} else {
+		maxTotalCores := float64(computeLimits.Absolute.MaxTotalCores)
+		maxTotalInstances := float64(computeLimits.Absolute.MaxTotalInstances)
+		maxTotalRAMSize := float64(computeLimits.Absolute.MaxTotalRAMSize)
+		totalCoresUsed := float64(computeLimits.Absolute.TotalCoresUsed)
+		totalInstancesUsed := float64(computeLimits.Absolute.TotalInstancesUsed)
+		totalRAMUsed := float64(computeLimits.Absolute.TotalRAMUsed)
+
+		ch <- prometheus.MustNewConstMetric(collector.maxTotalCores, prometheus.GaugeValue, maxTotalCores, labels...)
+		ch <- prometheus.MustNewConstMetric(collector.maxTotalInstances, prometheus.GaugeValue, maxTotalInstances, labels...)
+		ch <- prometheus.MustNewConstMetric(collector.maxTotalRAMSize, prometheus.GaugeValue, maxTotalRAMSize, labels...)
+		ch <- prometheus.MustNewConstMetric(collector.totalCoresUsed, prometheus.GaugeValue, totalCoresUsed, labels...)
+		ch <- prometheus.MustNewConstMetric(collector.totalInstancesUsed, prometheus.GaugeValue, totalInstancesUsed, labels...)
+		ch <- prometheus.MustNewConstMetric(collector.totalRAMUsed, prometheus.GaugeValue, totalRAMUsed, labels...)
+
+		for _, flavor := range flavorList {
+			capacity, constraint := flavorRemainingCapacity(flavor, computeLimits.Absolute)
+			ch <- prometheus.MustNewConstMetric(collector.flavorRemainingCapacity, prometheus.GaugeValue, capacity, target.labelValues(flavor.Name, constraint)...)
+		}
+	}
+
+	quotaDetails, err := getComputeQuota(ctx, providerClient, target)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get compute quota: %w", err))
+	} else {
+		for resource, quota := range quotaDetails {
+			ch <- prometheus.MustNewConstMetric(collector.quotaLimit, prometheus.GaugeValue, float64(quota.Limit), target.labelValues(resource)...)
+			ch <- prometheus.MustNewConstMetric(collector.quotaUsed, prometheus.GaugeValue, float64(quota.InUse), target.labelValues(resource)...)
+			ch <- prometheus.MustNewConstMetric(collector.quotaReserved, prometheus.GaugeValue, float64(quota.Reserved), target.labelValues(resource)...)
+		}
+	}
+
+	serverList, serverLocked, err := getAllServers(ctx, providerClient, target.CloudConfig)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get servers: %w", err))
+	} else {
+		// Flavors which are not listed are resolved by ID, they are
+		// described as well so their names can be joined
+		for _, flavor := range collector.flavors.unlisted(ctx, providerClient, target, serverList, flavorList) {
+			collector.collectFlavor(target, flavor, ch)
+		}
+
+		flavorCount := collector.countInstancePerFlavor(ctx, providerClient, target, serverList)
+		for flavor, count := range flavorCount {
+			flavorCountMetric := prometheus.MustNewConstMetric(collector.perFlavorInstanceCount, prometheus.GaugeValue, float64(count), target.labelValues(flavor)...)
+			ch <- flavorCountMetric
+		}
+
+		statusCountServers := countInstancePerStatus(serverList)
+		for status, count := range statusCountServers {
+			statusCountMetric := prometheus.MustNewConstMetric(collector.perStatusInstanceCount, prometheus.GaugeValue, float64(count), target.labelValues(status)...)
+			ch <- statusCountMetric
+		}
+
+		serverStatuses := make(map[string]string, len(serverList))
+		for _, server := range serverList {
+			serverStatuses[server.ID] = server.Status
+		}
+		collector.statuses.update(target, serverStatuses, time.Now(), ch)
+
+		flavorName := func(server servers.Server) string {
+			return collector.flavors.serverFlavorName(ctx, providerClient, target, server.Flavor)
+		}
+		collector.boots.update(target, serverList, flavorName, time.Now(), ch)
+
+		if *perServerInfo {
+			for _, server := range serverList {
+				collector.collectServer(ctx, providerClient, target, server, serverLocked[server.ID], ch)
+			}
+		}
+
+		if len(*serverLabelKeys) > 0 {
+			collector.collectPerTag(ctx, providerClient, target, serverList, ch)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
 type AbsoluteComputeLimits struct {
 	MaxTotalCores      int `json:"maxTotalCores"`
 	MaxTotalInstances  int `json:"maxTotalInstances"`
@@ -27,48 +260,196 @@ type Server struct {
 	Status string                 `json:"Status"`
 }
 
-func getAllServers(providerClient *gophercloud.ProviderClient) []servers.Server {
+// getAllServers returns the servers of the project and whether they are
+// locked, which is missing in servers.Server
+func getAllServers(ctx context.Context, providerClient *gophercloud.ProviderClient, cloudConfig *CloudConfig) ([]servers.Server, map[string]bool, error) {
 	// Create a Compute V2 service client
-	computeClient, err := openstack.NewComputeV2(providerClient, gophercloud.EndpointOpts{
-		Region: os.Getenv("OS_REGION_NAME"),
-	})
+	computeClient, err := openstack.NewComputeV2(providerClient, cloudConfig.EndpointOpts)
 	if err != nil {
-		level.Error(logger).Log("message", "Failed to create compute client", "err", err)
+		return nil, nil, err
 	}
+	// The locked state is reported since 2.9 and the tags since 2.26,
+	// flavors are embedded in the servers since 2.47
+	computeClient.Microversion = "2.26"
 	listOpts := servers.ListOpts{
 		AllTenants: false,
 	}
 
 	level.Debug(logger).Log("message", "Getting all servers")
 
-	allPages, err := servers.List(computeClient, listOpts).AllPages(context.TODO())
+	allPages, err := servers.List(computeClient, listOpts).AllPages(ctx)
 	if err != nil {
-		level.Error(logger).Log("message", "Failed to retrieve all servers", "err", err)
+		return nil, nil, err
 	}
 
 	allServers, err := servers.ExtractServers(allPages)
 	if err != nil {
-		level.Error(logger).Log("message", "Failed to retrieve all servers", "err", err)
+		return nil, nil, err
 	}
 
-	return allServers
+	var serverLocks []struct {
+		ID     string `json:"id"`
+		Locked bool   `json:"locked"`
+	}
+	if err := servers.ExtractServersInto(allPages, &serverLocks); err != nil {
+		return nil, nil, err
+	}
+	locked := make(map[string]bool, len(serverLocks))
+	for _, serverLock := range serverLocks {
+		locked[serverLock.ID] = serverLock.Locked
+	}
+
+	return allServers, locked, nil
 }
 
-func countInstancePerFlavor(serverList []servers.Server) map[string]int {
-	serverListJSON, err := json.Marshal(serverList)
+func getAllFlavors(ctx context.Context, providerClient *gophercloud.ProviderClient, cloudConfig *CloudConfig) ([]flavors.Flavor, error) {
+	computeClient, err := openstack.NewComputeV2(providerClient, cloudConfig.EndpointOpts)
 	if err != nil {
-		level.Error(logger).Log("message", "Error parsing server list", "err", err)
+		return nil, err
 	}
 
-	var servers []Server
-	if err := json.Unmarshal([]byte(serverListJSON), &servers); err != nil {
-		level.Error(logger).Log("message", "Error parsing JSON", "err", err)
+	level.Debug(logger).Log("message", "Getting all flavors")
+
+	allPages, err := flavors.ListDetail(computeClient, flavors.ListOpts{}).AllPages(ctx)
+	if err != nil {
+		return nil, err
 	}
+
+	return flavors.ExtractFlavors(allPages)
+}
+
+// flavorRemainingCapacity returns how many more instances of the flavor fit in
+// the remaining cores, RAM and instances of the limits, and which of them runs
+// out first. Unlimited quotas are no constraint, the capacity is +Inf when
+// none of them is limited.
+func flavorRemainingCapacity(flavor flavors.Flavor, limits computeLimits.Absolute) (float64, string) {
+	capacity, constraint := math.Inf(1), "none"
+
+	constrain := func(resource string, max, used, perInstance int) {
+		if max < 0 || perInstance <= 0 {
+			return
+		}
+		remaining := math.Max(math.Floor(float64(max-used)/float64(perInstance)), 0)
+		if remaining < capacity {
+			capacity, constraint = remaining, resource
+		}
+	}
+	constrain("instances", limits.MaxTotalInstances, limits.TotalInstancesUsed, 1)
+	constrain("cores", limits.MaxTotalCores, limits.TotalCoresUsed, flavor.VCPUs)
+	constrain("ram", limits.MaxTotalRAMSize, limits.TotalRAMUsed, flavor.RAM)
+
+	return capacity, constraint
+}
+
+// collectFlavor sends the info metric of a flavor
+func (collector *computeCollector) collectFlavor(target *Target, flavor flavors.Flavor, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(collector.flavorInfo, prometheus.GaugeValue, 1, target.labelValues(flavor.ID, flavor.Name, strconv.Itoa(flavor.VCPUs), strconv.Itoa(flavor.RAM), strconv.Itoa(flavor.Disk))...)
+}
+
+// collectServer sends the metrics of a single server
+func (collector *computeCollector) collectServer(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, server servers.Server, locked bool, ch chan<- prometheus.Metric) {
+	flavorName := collector.flavors.serverFlavorName(ctx, providerClient, target, server.Flavor)
+	// Servers booted from volume have no image
+	imageID, _ := server.Image["id"].(string)
+	lockedValue := 0.0
+	if locked {
+		lockedValue = 1
+	}
+
+	serverLabels := serverLabelValues(server)
+
+	ch <- prometheus.MustNewConstMetric(collector.serverInfo, prometheus.GaugeValue, 1, target.labelValues(append([]string{server.ID, server.Name, flavorName, imageID, server.AvailabilityZone, server.KeyName}, serverLabels...)...)...)
+	ch <- prometheus.MustNewConstMetric(collector.serverCreated, prometheus.GaugeValue, float64(server.Created.Unix()), target.labelValues(append([]string{server.ID}, serverLabels...)...)...)
+	ch <- prometheus.MustNewConstMetric(collector.serverPowerState, prometheus.GaugeValue, float64(server.PowerState), target.labelValues(append([]string{server.ID, server.TaskState, server.VmState}, serverLabels...)...)...)
+	ch <- prometheus.MustNewConstMetric(collector.serverLocked, prometheus.GaugeValue, lockedValue, target.labelValues(append([]string{server.ID}, serverLabels...)...)...)
+}
+
+// collectPerTag sends the number of instances and their resources per value
+// of the server labels
+func (collector *computeCollector) collectPerTag(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, serverList []servers.Server, ch chan<- prometheus.Metric) {
+	type tagUsage struct {
+		labels []string
+		count  int
+		vcpus  int
+		ram    int
+	}
+	perTag := make(map[string]*tagUsage)
+
+	for _, server := range serverList {
+		labels := serverLabelValues(server)
+		key := strings.Join(labels, "\x00")
+		usage, ok := perTag[key]
+		if !ok {
+			usage = &tagUsage{labels: labels}
+			perTag[key] = usage
+		}
+		usage.count++
+		if flavor, ok := collector.flavors.serverFlavor(ctx, providerClient, target, server.Flavor); ok {
+			usage.vcpus += flavor.VCPUs
+			usage.ram += flavor.RAM
+		}
+	}
+
+	for _, usage := range perTag {
+		ch <- prometheus.MustNewConstMetric(collector.perTagInstanceCount, prometheus.GaugeValue, float64(usage.count), target.labelValues(usage.labels...)...)
+		ch <- prometheus.MustNewConstMetric(collector.perTagInstanceVCPUs, prometheus.GaugeValue, float64(usage.vcpus), target.labelValues(usage.labels...)...)
+		ch <- prometheus.MustNewConstMetric(collector.perTagInstanceRAM, prometheus.GaugeValue, float64(usage.ram), target.labelValues(usage.labels...)...)
+	}
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// serverLabelNames returns the label names of the --compute.server-label keys,
+// prefixed so they cannot clash with the other labels
+func serverLabelNames() []string {
+	names := make([]string, 0, len(*serverLabelKeys))
+	for _, key := range *serverLabelKeys {
+		names = append(names, "tag_"+invalidLabelChars.ReplaceAllString(key, "_"))
+	}
+	return names
+}
+
+// validateServerLabels rejects --compute.server-label keys which end up as the
+// same label name, like cost-center and cost_center
+func validateServerLabels() error {
+	keys := make(map[string]string, len(*serverLabelKeys))
+	for i, name := range serverLabelNames() {
+		key := (*serverLabelKeys)[i]
+		if previous, ok := keys[name]; ok {
+			return fmt.Errorf("--compute.server-label %q and %q both map to the label %s", previous, key, name)
+		}
+		keys[name] = key
+	}
+	return nil
+}
+
+// serverLabelValues returns the values of the --compute.server-label keys for
+// the server. The metadata takes precedence over the tags, the value is empty
+// when the server has neither.
+func serverLabelValues(server servers.Server) []string {
+	values := make([]string, 0, len(*serverLabelKeys))
+	for _, key := range *serverLabelKeys {
+		value, ok := server.Metadata[key]
+		if !ok && server.Tags != nil {
+			for _, tag := range *server.Tags {
+				if tagValue, found := strings.CutPrefix(tag, key+"="); found {
+					value = tagValue
+					break
+				}
+			}
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// countInstancePerFlavor counts the servers by the name of their flavor
+func (collector *computeCollector) countInstancePerFlavor(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, serverList []servers.Server) map[string]int {
 	flavorCount := make(map[string]int)
 
-	for _, server := range servers {
-		flavorID := server.Flavor["id"].(string)
-		flavorCount[flavorID]++
+	for _, server := range serverList {
+		flavorName := collector.flavors.serverFlavorName(ctx, providerClient, target, server.Flavor)
+		flavorCount[flavorName]++
 	}
 
 	return flavorCount
@@ -93,22 +474,53 @@ func countInstancePerStatus(serverList []servers.Server) map[string]int {
 
 	return statusCount
 }
-func getComputeLimits(providerClient *gophercloud.ProviderClient) *computeLimits.Limits {
+func getComputeLimits(ctx context.Context, providerClient *gophercloud.ProviderClient, cloudConfig *CloudConfig) (*computeLimits.Limits, error) {
 	// Create a Compute V2 service client
-	computeClient, err := openstack.NewComputeV2(providerClient, gophercloud.EndpointOpts{
-		Region: os.Getenv("OS_REGION_NAME"),
-	})
+	computeClient, err := openstack.NewComputeV2(providerClient, cloudConfig.EndpointOpts)
 	if err != nil {
-		level.Error(logger).Log("message", "Failed to create compute client", "err", err)
+		return nil, err
 	}
 
 	getOpts := computeLimits.GetOpts{}
 
 	level.Debug(logger).Log("message", "Getting compute limits")
-	computeLimits, err := computeLimits.Get(context.TODO(), computeClient, getOpts).Extract()
+	computeLimits, err := computeLimits.Get(ctx, computeClient, getOpts).Extract()
 	if err != nil {
-		level.Error(logger).Log("message", "Failed to retrieve limits", "err", err)
+		return nil, err
 	}
 
-	return computeLimits
+	return computeLimits, nil
+}
+
+// getComputeQuota returns the limit and usage of every compute resource in the
+// project, keyed by the names Nova uses. The keys are taken from the response,
+// so resources Nova adds or drops need no changes here.
+func getComputeQuota(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target) (map[string]computeQuotasets.QuotaDetail, error) {
+	computeClient, err := openstack.NewComputeV2(providerClient, target.CloudConfig.EndpointOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	level.Debug(logger).Log("message", "Getting compute quota")
+
+	var result struct {
+		QuotaSet map[string]json.RawMessage `json:"quota_set"`
+	}
+	if err := computeQuotasets.GetDetail(ctx, computeClient, target.ProjectID()).ExtractInto(&result); err != nil {
+		return nil, err
+	}
+
+	quotaDetails := make(map[string]computeQuotasets.QuotaDetail)
+	for resource, value := range result.QuotaSet {
+		// Only the quotas are objects, the project ID is not
+		if resource == "id" {
+			continue
+		}
+		var quota computeQuotasets.QuotaDetail
+		if err := json.Unmarshal(value, &quota); err != nil {
+			return nil, fmt.Errorf("failed to parse quota %s: %w", resource, err)
+		}
+		quotaDetails[resource] = quota
+	}
+	return quotaDetails, nil
 }
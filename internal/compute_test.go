@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/flavors"
+	computeLimits "github.com/gophercloud/gophercloud/v2/openstack/compute/v2/limits"
+)
+
+func TestFlavorRemainingCapacity(t *testing.T) {
+	small := flavors.Flavor{Name: "small", VCPUs: 2, RAM: 4096}
+
+	tests := []struct {
+		name       string
+		flavor     flavors.Flavor
+		limits     computeLimits.Absolute
+		capacity   float64
+		constraint string
+	}{
+		{
+			name:   "cores run out first",
+			flavor: small,
+			limits: computeLimits.Absolute{
+				MaxTotalInstances: 20, TotalInstancesUsed: 3,
+				MaxTotalCores: 10, TotalCoresUsed: 5,
+				MaxTotalRAMSize: 102400, TotalRAMUsed: 8192,
+			},
+			capacity:   2,
+			constraint: "cores",
+		},
+		{
+			name:   "ram runs out first",
+			flavor: small,
+			limits: computeLimits.Absolute{
+				MaxTotalInstances: 20, TotalInstancesUsed: 3,
+				MaxTotalCores: 100, TotalCoresUsed: 6,
+				MaxTotalRAMSize: 20480, TotalRAMUsed: 12288,
+			},
+			capacity:   2,
+			constraint: "ram",
+		},
+		{
+			name:   "instances run out first",
+			flavor: small,
+			limits: computeLimits.Absolute{
+				MaxTotalInstances: 4, TotalInstancesUsed: 3,
+				MaxTotalCores: 100, TotalCoresUsed: 6,
+				MaxTotalRAMSize: 102400, TotalRAMUsed: 12288,
+			},
+			capacity:   1,
+			constraint: "instances",
+		},
+		{
+			name:   "unlimited quotas are no constraint",
+			flavor: small,
+			limits: computeLimits.Absolute{
+				MaxTotalInstances: -1, TotalInstancesUsed: 3,
+				MaxTotalCores: -1, TotalCoresUsed: 6,
+				MaxTotalRAMSize: 20480, TotalRAMUsed: 12288,
+			},
+			capacity:   2,
+			constraint: "ram",
+		},
+		{
+			name:   "everything unlimited",
+			flavor: small,
+			limits: computeLimits.Absolute{
+				MaxTotalInstances: -1,
+				MaxTotalCores:     -1,
+				MaxTotalRAMSize:   -1,
+			},
+			capacity:   math.Inf(1),
+			constraint: "none",
+		},
+		{
+			name:   "quota exceeded",
+			flavor: small,
+			limits: computeLimits.Absolute{
+				MaxTotalInstances: 20, TotalInstancesUsed: 3,
+				MaxTotalCores: 4, TotalCoresUsed: 6,
+				MaxTotalRAMSize: 102400, TotalRAMUsed: 12288,
+			},
+			capacity:   0,
+			constraint: "cores",
+		},
+		{
+			name:   "flavor without resources",
+			flavor: flavors.Flavor{Name: "empty"},
+			limits: computeLimits.Absolute{
+				MaxTotalInstances: 20, TotalInstancesUsed: 3,
+				MaxTotalCores: 4, TotalCoresUsed: 4,
+				MaxTotalRAMSize: 4096, TotalRAMUsed: 4096,
+			},
+			capacity:   17,
+			constraint: "instances",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			capacity, constraint := flavorRemainingCapacity(test.flavor, test.limits)
+			if capacity != test.capacity || constraint != test.constraint {
+				t.Errorf("expected %v constrained by %s, got %v constrained by %s", test.capacity, test.constraint, capacity, constraint)
+			}
+		})
+	}
+}
+
+func TestValidateServerLabels(t *testing.T) {
+	tests := []struct {
+		keys  []string
+		valid bool
+	}{
+		{keys: nil, valid: true},
+		{keys: []string{"team", "cost-center"}, valid: true},
+		{keys: []string{"cost-center", "cost_center"}},
+		{keys: []string{"team", "team"}},
+		{keys: []string{"a.b", "a/b"}},
+	}
+
+	keys := *serverLabelKeys
+	defer func() { *serverLabelKeys = keys }()
+	for _, test := range tests {
+		*serverLabelKeys = test.keys
+		if err := validateServerLabels(); (err == nil) != test.valid {
+			t.Errorf("expected valid %t for %v, got %v", test.valid, test.keys, err)
+		}
+	}
+}
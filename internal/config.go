@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the content of the exporter configuration file
+type Config struct {
+	Targets []TargetConfig    `yaml:"targets"`
+	Modules map[string]Module `yaml:"modules"`
+}
+
+// TargetConfig describes a single cloud/project/region to scrape. Cloud is
+// the name of the entry in clouds.yaml, ProjectID and Region override the
+// values found there. Provider overrides --provider.
+type TargetConfig struct {
+	Name        string      `yaml:"name"`
+	Cloud       string      `yaml:"cloud"`
+	ProjectID   string      `yaml:"project_id"`
+	Region      string      `yaml:"region"`
+	Provider    string      `yaml:"provider"`
+	StaticQuota StaticQuota `yaml:"static_quota"`
+}
+
+// StaticQuota is the quota reported for the resources the quota API of the
+// provider does not cover
+type StaticQuota struct {
+	Volumes   *float64 `yaml:"volumes"`
+	Gigabytes *float64 `yaml:"gigabytes"`
+}
+
+// Module is a named set of collectors which can be selected when probing
+type Module struct {
+	Collectors []string `yaml:"collectors"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	names := make(map[string]bool)
+	for i, target := range config.Targets {
+		if target.Cloud == "" {
+			return nil, fmt.Errorf("target %d in %s has no cloud", i, path)
+		}
+		if target.Provider != "" && !isProviderName(target.Provider) {
+			return nil, fmt.Errorf("unknown provider %q for target %d in %s", target.Provider, i, path)
+		}
+		name := target.name()
+		if names[name] {
+			return nil, fmt.Errorf("duplicate target %q in %s", name, path)
+		}
+		names[name] = true
+	}
+
+	for name, module := range config.Modules {
+		for _, collector := range module.Collectors {
+			if !isCollectorName(collector) {
+				return nil, fmt.Errorf("unknown collector %q in module %q in %s", collector, name, path)
+			}
+		}
+	}
+
+	return &config, nil
+}
+
+func (t TargetConfig) name() string {
+	return coalesce(t.Name, t.Cloud, "default")
+}
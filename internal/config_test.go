@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		targets []string
+		err     string
+	}{
+		{
+			name: "valid",
+			content: `
+targets:
+  - cloud: cloudferro
+  - name: otc-analytics
+    cloud: otc
+    project_id: 0123456789abcdef
+    region: eu-de
+    provider: otc
+modules:
+  compute:
+    collectors: [compute, volume]
+`,
+			targets: []string{"cloudferro", "otc-analytics"},
+		},
+		{
+			name: "same cloud with different names",
+			content: `
+targets:
+  - name: a
+    cloud: otc
+  - name: b
+    cloud: otc
+`,
+			targets: []string{"a", "b"},
+		},
+		{
+			name:    "missing cloud",
+			content: "targets:\n  - name: a\n",
+			err:     "has no cloud",
+		},
+		{
+			name:    "unknown provider",
+			content: "targets:\n  - cloud: otc\n    provider: aws\n",
+			err:     `unknown provider "aws"`,
+		},
+		{
+			name:    "duplicate name",
+			content: "targets:\n  - cloud: otc\n  - name: otc\n    cloud: cloudferro\n",
+			err:     `duplicate target "otc"`,
+		},
+		{
+			name:    "unknown collector",
+			content: "modules:\n  m:\n    collectors: [compute, dns]\n",
+			err:     `unknown collector "dns"`,
+		},
+		{
+			name:    "unknown field",
+			content: "targets:\n  - cloud: otc\n    project: p\n",
+			err:     "failed to parse",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yml")
+			if err := os.WriteFile(path, []byte(test.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := LoadConfig(path)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var names []string
+			for _, target := range config.Targets {
+				names = append(names, target.name())
+			}
+			if strings.Join(names, ",") != strings.Join(test.targets, ",") {
+				t.Errorf("expected targets %v, got %v", test.targets, names)
+			}
+		})
+	}
+}
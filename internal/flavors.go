@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+)
+
+// flavorMissTTL is how long a flavor which could not be fetched is not
+// requested again
+const flavorMissTTL = 15 * time.Minute
+
+// flavorCache resolves the flavor IDs of the servers to the flavors. Flavors
+// stay cached after they were deleted or are no longer listed, as servers keep
+// using them. Flavors which cannot be fetched are remembered as well, so
+// servers of a deleted flavor do not cause a request each.
+type flavorCache struct {
+	mu      sync.Mutex
+	flavors map[*Target]map[string]flavors.Flavor
+	misses  map[*Target]map[string]time.Time
+}
+
+func newFlavorCache() *flavorCache {
+	return &flavorCache{
+		flavors: make(map[*Target]map[string]flavors.Flavor),
+		misses:  make(map[*Target]map[string]time.Time),
+	}
+}
+
+// update adds the listed flavors of the target to the cache
+func (c *flavorCache) update(target *Target, flavorList []flavors.Flavor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	targetFlavors, ok := c.flavors[target]
+	if !ok {
+		targetFlavors = make(map[string]flavors.Flavor)
+		c.flavors[target] = targetFlavors
+	}
+	for _, flavor := range flavorList {
+		targetFlavors[flavor.ID] = flavor
+	}
+}
+
+// get returns the flavor of the target with the ID, fetching it when it is
+// not cached. Private flavors of other projects are not listed, but can be
+// fetched by ID. A flavor which could not be fetched is only requested again
+// after flavorMissTTL, listed flavors are found before that.
+func (c *flavorCache) get(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, flavorID string) (flavors.Flavor, bool) {
+	c.mu.Lock()
+	flavor, ok := c.flavors[target][flavorID]
+	missed, known := c.misses[target][flavorID]
+	c.mu.Unlock()
+	if ok {
+		return flavor, true
+	}
+	if known && time.Since(missed) < flavorMissTTL {
+		return flavors.Flavor{}, false
+	}
+
+	computeClient, err := openstack.NewComputeV2(providerClient, target.CloudConfig.EndpointOpts)
+	if err != nil {
+		return flavors.Flavor{}, false
+	}
+	level.Debug(logger).Log("message", "Getting flavor", "flavor", flavorID)
+	fetched, err := flavors.Get(ctx, computeClient, flavorID).Extract()
+	if err != nil {
+		level.Debug(logger).Log("message", "Failed to get flavor", "flavor", flavorID, "err", err)
+		// A cancelled scrape says nothing about the flavor
+		if ctx.Err() == nil {
+			c.miss(target, flavorID)
+		}
+		return flavors.Flavor{}, false
+	}
+
+	c.update(target, []flavors.Flavor{*fetched})
+	return *fetched, true
+}
+
+func (c *flavorCache) miss(target *Target, flavorID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	targetMisses, ok := c.misses[target]
+	if !ok {
+		targetMisses = make(map[string]time.Time)
+		c.misses[target] = targetMisses
+	}
+	targetMisses[flavorID] = time.Now()
+}
+
+// unlisted returns the flavors the servers reference by ID which are not in
+// the flavor list, like private flavors of other projects
+func (c *flavorCache) unlisted(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, serverList []servers.Server, flavorList []flavors.Flavor) []flavors.Flavor {
+	seen := make(map[string]bool, len(flavorList))
+	for _, flavor := range flavorList {
+		seen[flavor.ID] = true
+	}
+
+	var unlisted []flavors.Flavor
+	for _, server := range serverList {
+		flavorID, ok := server.Flavor["id"].(string)
+		if !ok || seen[flavorID] {
+			continue
+		}
+		seen[flavorID] = true
+		if flavor, ok := c.get(ctx, providerClient, target, flavorID); ok {
+			unlisted = append(unlisted, flavor)
+		}
+	}
+	return unlisted
+}
+
+// serverFlavor returns the flavor of a server. Since microversion 2.47 the
+// flavor is embedded in the server without its ID, before it only references
+// the flavor by ID, which is resolved with the cache.
+func (c *flavorCache) serverFlavor(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, serverFlavor map[string]any) (flavors.Flavor, bool) {
+	if name, ok := serverFlavor["original_name"].(string); ok {
+		// Numbers of the embedded flavor are decoded as float64
+		vcpus, _ := serverFlavor["vcpus"].(float64)
+		ram, _ := serverFlavor["ram"].(float64)
+		disk, _ := serverFlavor["disk"].(float64)
+		return flavors.Flavor{Name: name, VCPUs: int(vcpus), RAM: int(ram), Disk: int(disk)}, true
+	}
+	flavorID, ok := serverFlavor["id"].(string)
+	if !ok {
+		return flavors.Flavor{}, false
+	}
+	return c.get(ctx, providerClient, target, flavorID)
+}
+
+// serverFlavorName returns the name of the flavor of a server, or its ID when
+// it cannot be resolved
+func (c *flavorCache) serverFlavorName(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, serverFlavor map[string]any) string {
+	if flavor, ok := c.serverFlavor(ctx, providerClient, target, serverFlavor); ok {
+		return flavor.Name
+	}
+	flavorID, _ := serverFlavor["id"].(string)
+	return flavorID
+}
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type metricsHandler struct {
+	targets       []*Target
+	poller        *Poller
+	timeoutOffset time.Duration
+}
+
+// NewMetricsHandler returns a handler which collects all targets with the
+// enabled collectors, or only the ones selected with the collect[] parameter.
+// The collection is cancelled before Prometheus gives up on the scrape, the
+// timeout offset leaves some time to send the response. When a poller is
+// given, its snapshots are served instead.
+func NewMetricsHandler(targets []*Target, poller *Poller, timeoutOffset time.Duration) http.Handler {
+	return &metricsHandler{
+		targets:       targets,
+		poller:        poller,
+		timeoutOffset: timeoutOffset,
+	}
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	filters := r.URL.Query()["collect[]"]
+	if len(filters) > 0 {
+		level.Debug(logger).Log("message", "Collecting filtered metrics", "collect", fmt.Sprint(filters))
+	}
+
+	collector, err := NewOpenStackCollector(h.targets, h.poller, scrapeTimeout(r, h.timeoutOffset), filters...)
+	if err != nil {
+		level.Warn(logger).Log("message", "Couldn't create collector", "err", err)
+		http.Error(w, fmt.Sprintf("Couldn't create collector: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	// Custom registry to not collect all go low-level metrics
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// scrapeTimeout returns the timeout Prometheus set for the scrape minus the
+// offset, or zero when the request has no timeout
+func scrapeTimeout(r *http.Request, offset time.Duration) time.Duration {
+	header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if header == "" {
+		return 0
+	}
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil || seconds <= 0 {
+		level.Warn(logger).Log("message", "Invalid scrape timeout", "timeout", header, "err", err)
+		return 0
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > offset {
+		timeout -= offset
+	}
+	return timeout
+}
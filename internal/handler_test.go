@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScrapeTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		offset  time.Duration
+		timeout time.Duration
+	}{
+		{name: "no header", header: "", offset: 500 * time.Millisecond, timeout: 0},
+		{name: "offset subtracted", header: "10", offset: 500 * time.Millisecond, timeout: 9500 * time.Millisecond},
+		{name: "fractional seconds", header: "2.5", offset: 0, timeout: 2500 * time.Millisecond},
+		{name: "offset larger than timeout", header: "0.3", offset: 500 * time.Millisecond, timeout: 300 * time.Millisecond},
+		{name: "offset equal to timeout", header: "0.5", offset: 500 * time.Millisecond, timeout: 500 * time.Millisecond},
+		{name: "not a number", header: "ten", offset: 500 * time.Millisecond, timeout: 0},
+		{name: "zero", header: "0", offset: 500 * time.Millisecond, timeout: 0},
+		{name: "negative", header: "-5", offset: 500 * time.Millisecond, timeout: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			if test.header != "" {
+				r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", test.header)
+			}
+			if timeout := scrapeTimeout(r, test.offset); timeout != test.timeout {
+				t.Errorf("expected timeout %s, got %s", test.timeout, timeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log/level"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/gophercloud/v2/openstack/image/v2/images"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var perImageSize = kingpin.Flag("image.per-image-size", "Export the size and creation time of every image, which adds series per image").Default("false").Bool()
+
+func init() {
+	registerCollector("image", "image", true, newImageCollector)
+}
+
+type imageCollector struct {
+	imageCount   *prometheus.Desc
+	imageBytes   *prometheus.Desc
+	imageSize    *prometheus.Desc
+	imageCreated *prometheus.Desc
+	quotaLimit   *prometheus.Desc
+	quotaUsed    *prometheus.Desc
+}
+
+func newImageCollector() Collector {
+	return &imageCollector{
+		imageCount: prometheus.NewDesc("openstack_image_count",
+			"Number of images per visibility, status, disk format and owner",
+			targetLabels("visibility", "status", "disk_format", "owner"), nil,
+		),
+		imageBytes: prometheus.NewDesc("openstack_image_bytes",
+			"The total size of the images in bytes per visibility, status, disk format and owner",
+			targetLabels("visibility", "status", "disk_format", "owner"), nil,
+		),
+		imageSize: prometheus.NewDesc("openstack_image_size_bytes",
+			"The size of the image in bytes",
+			targetLabels("id", "name"), nil,
+		),
+		imageCreated: prometheus.NewDesc("openstack_image_created_timestamp_seconds",
+			"The time the image was created in seconds since epoch",
+			targetLabels("id", "name"), nil,
+		),
+		quotaLimit: prometheus.NewDesc("openstack_image_quota_limit",
+			"The quota limit of the image resource in the project, -1 when unlimited",
+			targetLabels("resource"), nil,
+		),
+		quotaUsed: prometheus.NewDesc("openstack_image_quota_used",
+			"The current usage of the image resource in the project",
+			targetLabels("resource"), nil,
+		),
+	}
+}
+
+func (collector *imageCollector) Update(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, ch chan<- prometheus.Metric) error {
+	var errs []error
+
+	imageList, err := getAllImages(ctx, providerClient, target.CloudConfig)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get images: %w", err))
+	} else {
+		imageCount := make(map[imageKey]int)
+		imageBytes := make(map[imageKey]int64)
+		for _, image := range imageList {
+			key := imageKey{
+				visibility: string(image.Visibility),
+				status:     string(image.Status),
+				diskFormat: image.DiskFormat,
+				owner:      image.Owner,
+			}
+			imageCount[key]++
+			imageBytes[key] += image.SizeBytes
+
+			if *perImageSize {
+				ch <- prometheus.MustNewConstMetric(collector.imageCreated, prometheus.GaugeValue, float64(image.CreatedAt.Unix()), target.labelValues(image.ID, image.Name)...)
+				ch <- prometheus.MustNewConstMetric(collector.imageSize, prometheus.GaugeValue, float64(image.SizeBytes), target.labelValues(image.ID, image.Name)...)
+			}
+		}
+		for key, count := range imageCount {
+			labels := target.labelValues(key.visibility, key.status, key.diskFormat, key.owner)
+			ch <- prometheus.MustNewConstMetric(collector.imageCount, prometheus.GaugeValue, float64(count), labels...)
+			ch <- prometheus.MustNewConstMetric(collector.imageBytes, prometheus.GaugeValue, float64(imageBytes[key]), labels...)
+		}
+	}
+
+	imageUsage, err := getImageUsage(ctx, providerClient, target.CloudConfig)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get image quota: %w", err))
+	} else {
+		for resource, usage := range imageUsage {
+			ch <- prometheus.MustNewConstMetric(collector.quotaLimit, prometheus.GaugeValue, usage.Limit, target.labelValues(resource)...)
+			ch <- prometheus.MustNewConstMetric(collector.quotaUsed, prometheus.GaugeValue, usage.Usage, target.labelValues(resource)...)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+type imageKey struct {
+	visibility string
+	status     string
+	diskFormat string
+	owner      string
+}
+
+// ImageUsage is the limit and usage of an image resource
+type ImageUsage struct {
+	Limit float64 `json:"limit"`
+	Usage float64 `json:"usage"`
+}
+
+// getAllImages lists the images the project can see, which includes the
+// public and shared images of other owners
+func getAllImages(ctx context.Context, providerClient *gophercloud.ProviderClient, cloudConfig *CloudConfig) ([]images.Image, error) {
+	imageClient, err := openstack.NewImageV2(providerClient, cloudConfig.EndpointOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	level.Debug(logger).Log("message", "Getting all images")
+
+	allPages, err := images.List(imageClient, images.ListOpts{}).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return images.ExtractImages(allPages)
+}
+
+// getImageUsage returns the quota usage of the project, keyed by the resource
+// names Glance uses. Glance only reports it with unified limits since Yoga,
+// nil is returned when it does not.
+func getImageUsage(ctx context.Context, providerClient *gophercloud.ProviderClient, cloudConfig *CloudConfig) (map[string]ImageUsage, error) {
+	imageClient, err := openstack.NewImageV2(providerClient, cloudConfig.EndpointOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	level.Debug(logger).Log("message", "Getting image usage")
+
+	var result struct {
+		Usage map[string]ImageUsage `json:"usage"`
+	}
+	_, err = imageClient.Get(ctx, imageClient.ServiceURL("info", "usage"), &result, nil)
+	if err != nil {
+		if gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return result.Usage, nil
+}
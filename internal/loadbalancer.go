@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-kit/log/level"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/gophercloud/v2/openstack/loadbalancer/v2/listeners"
+	"github.com/gophercloud/gophercloud/v2/openstack/loadbalancer/v2/loadbalancers"
+	"github.com/gophercloud/gophercloud/v2/openstack/loadbalancer/v2/pools"
+	lbQuotas "github.com/gophercloud/gophercloud/v2/openstack/loadbalancer/v2/quotas"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	// Octavia is not deployed on every cloud
+	registerCollector("loadbalancer", "loadbalancer", false, newLoadBalancerCollector)
+}
+
+type loadBalancerCollector struct {
+	quotaLimit         *prometheus.Desc
+	quotaUsed          *prometheus.Desc
+	perStatusLBs       *prometheus.Desc
+	perStatusListeners *prometheus.Desc
+	perStatusPools     *prometheus.Desc
+	perStatusMembers   *prometheus.Desc
+	poolMembers        *prometheus.Desc
+}
+
+func newLoadBalancerCollector() Collector {
+	return &loadBalancerCollector{
+		quotaLimit: prometheus.NewDesc("openstack_loadbalancer_quota_limit",
+			"The quota limit of the load balancer resource in the project, -1 when unlimited",
+			targetLabels("resource"), nil,
+		),
+		quotaUsed: prometheus.NewDesc("openstack_loadbalancer_quota_used",
+			"The current number of the load balancer resource used in the project",
+			targetLabels("resource"), nil,
+		),
+		perStatusLBs: prometheus.NewDesc("openstack_loadbalancer_count",
+			"Number of load balancers per provisioning and operating status",
+			targetLabels("provisioning_status", "operating_status"), nil,
+		),
+		perStatusListeners: prometheus.NewDesc("openstack_loadbalancer_listener_count",
+			"Number of load balancer listeners per provisioning and operating status",
+			targetLabels("provisioning_status", "operating_status"), nil,
+		),
+		perStatusPools: prometheus.NewDesc("openstack_loadbalancer_pool_count",
+			"Number of load balancer pools per provisioning and operating status",
+			targetLabels("provisioning_status", "operating_status"), nil,
+		),
+		perStatusMembers: prometheus.NewDesc("openstack_loadbalancer_member_count",
+			"Number of load balancer pool members per provisioning and operating status",
+			targetLabels("provisioning_status", "operating_status"), nil,
+		),
+		poolMembers: prometheus.NewDesc("openstack_loadbalancer_pool_members",
+			"Number of members of the load balancer pool per operating status",
+			targetLabels("pool_id", "pool_name", "operating_status"), nil,
+		),
+	}
+}
+
+func (collector *loadBalancerCollector) Update(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, ch chan<- prometheus.Metric) error {
+	lbClient, err := target.Provider().LoadBalancerClient(providerClient, target)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	used := make(map[string]int)
+
+	lbList, err := getAllLoadBalancers(ctx, lbClient, target.ProjectID())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get load balancers: %w", err))
+	} else {
+		used["loadbalancer"] = len(lbList)
+		statusCount := make(map[lbStatusKey]int)
+		for _, lb := range lbList {
+			statusCount[lbStatusKey{lb.ProvisioningStatus, lb.OperatingStatus}]++
+		}
+		for key, count := range statusCount {
+			ch <- prometheus.MustNewConstMetric(collector.perStatusLBs, prometheus.GaugeValue, float64(count), target.labelValues(key.provisioningStatus, key.operatingStatus)...)
+		}
+	}
+
+	listenerList, err := getAllListeners(ctx, lbClient, target.ProjectID())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get listeners: %w", err))
+	} else {
+		used["listener"] = len(listenerList)
+		statusCount := make(map[lbStatusKey]int)
+		for _, listener := range listenerList {
+			statusCount[lbStatusKey{listener.ProvisioningStatus, listener.OperatingStatus}]++
+		}
+		for key, count := range statusCount {
+			ch <- prometheus.MustNewConstMetric(collector.perStatusListeners, prometheus.GaugeValue, float64(count), target.labelValues(key.provisioningStatus, key.operatingStatus)...)
+		}
+	}
+
+	poolList, err := getAllPools(ctx, lbClient, target.ProjectID())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get pools: %w", err))
+	} else {
+		used["pool"] = len(poolList)
+		poolStatusCount := make(map[lbStatusKey]int)
+		memberStatusCount := make(map[lbStatusKey]int)
+		membersOK := true
+		for _, pool := range poolList {
+			poolStatusCount[lbStatusKey{pool.ProvisioningStatus, pool.OperatingStatus}]++
+
+			memberList, err := getAllMembers(ctx, lbClient, pool.ID)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to get members of pool %s: %w", pool.ID, err))
+				membersOK = false
+				continue
+			}
+			used["member"] += len(memberList)
+
+			healthCount := make(map[string]int)
+			for _, member := range memberList {
+				memberStatusCount[lbStatusKey{member.ProvisioningStatus, member.OperatingStatus}]++
+				healthCount[member.OperatingStatus]++
+			}
+			for status, count := range healthCount {
+				ch <- prometheus.MustNewConstMetric(collector.poolMembers, prometheus.GaugeValue, float64(count), target.labelValues(pool.ID, pool.Name, status)...)
+			}
+		}
+		for key, count := range poolStatusCount {
+			ch <- prometheus.MustNewConstMetric(collector.perStatusPools, prometheus.GaugeValue, float64(count), target.labelValues(key.provisioningStatus, key.operatingStatus)...)
+		}
+		for key, count := range memberStatusCount {
+			ch <- prometheus.MustNewConstMetric(collector.perStatusMembers, prometheus.GaugeValue, float64(count), target.labelValues(key.provisioningStatus, key.operatingStatus)...)
+		}
+		// The member count is wrong when a pool is missing
+		if !membersOK {
+			delete(used, "member")
+		}
+	}
+
+	quota, err := target.Provider().LoadBalancerQuota(ctx, lbClient, target)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get load balancer quota: %w", err))
+	} else {
+		limits := map[string]int{
+			"healthmonitor": quota.Healthmonitor,
+			"l7policy":      quota.L7Policy,
+			"l7rule":        quota.L7Rule,
+			"listener":      quota.Listener,
+			"loadbalancer":  quota.Loadbalancer,
+			"member":        quota.Member,
+			"pool":          quota.Pool,
+		}
+		for resource, limit := range limits {
+			ch <- prometheus.MustNewConstMetric(collector.quotaLimit, prometheus.GaugeValue, float64(limit), target.labelValues(resource)...)
+		}
+	}
+	// Octavia does not report the usage, so it is counted from the resources
+	for resource, count := range used {
+		ch <- prometheus.MustNewConstMetric(collector.quotaUsed, prometheus.GaugeValue, float64(count), target.labelValues(resource)...)
+	}
+
+	return errors.Join(errs...)
+}
+
+type lbStatusKey struct {
+	provisioningStatus string
+	operatingStatus    string
+}
+
+// getLoadBalancerClient returns a client for the Octavia API
+func getLoadBalancerClient(providerClient *gophercloud.ProviderClient, target *Target) (*gophercloud.ServiceClient, error) {
+	return openstack.NewLoadBalancerV2(providerClient, target.CloudConfig.EndpointOpts)
+}
+
+func getLoadBalancerQuota(ctx context.Context, lbClient *gophercloud.ServiceClient, projectID string) (*lbQuotas.Quota, error) {
+	level.Debug(logger).Log("message", "Getting load balancer quota")
+
+	return lbQuotas.Get(ctx, lbClient, projectID).Extract()
+}
+
+func getAllLoadBalancers(ctx context.Context, lbClient *gophercloud.ServiceClient, projectID string) ([]loadbalancers.LoadBalancer, error) {
+	level.Debug(logger).Log("message", "Getting all load balancers")
+
+	allPages, err := loadbalancers.List(lbClient, loadbalancers.ListOpts{ProjectID: projectID}).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return loadbalancers.ExtractLoadBalancers(allPages)
+}
+
+func getAllListeners(ctx context.Context, lbClient *gophercloud.ServiceClient, projectID string) ([]listeners.Listener, error) {
+	level.Debug(logger).Log("message", "Getting all listeners")
+
+	allPages, err := listeners.List(lbClient, listeners.ListOpts{ProjectID: projectID}).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return listeners.ExtractListeners(allPages)
+}
+
+func getAllPools(ctx context.Context, lbClient *gophercloud.ServiceClient, projectID string) ([]pools.Pool, error) {
+	level.Debug(logger).Log("message", "Getting all pools")
+
+	allPages, err := pools.List(lbClient, pools.ListOpts{ProjectID: projectID}).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return pools.ExtractPools(allPages)
+}
+
+func getAllMembers(ctx context.Context, lbClient *gophercloud.ServiceClient, poolID string) ([]pools.Member, error) {
+	level.Debug(logger).Log("message", "Getting all members", "pool", poolID)
+
+	allPages, err := pools.ListMembers(lbClient, poolID, pools.ListMembersOpts{}).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return pools.ExtractMembers(allPages)
+}
@@ -0,0 +1,270 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/go-kit/log/level"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/layer3/routers"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/networkipavailabilities"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/quotas"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/ports"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("network", "network", true, newNetworkCollector)
+	// The IP availability API is restricted to admins by the default policy
+	registerCollector("ipavailability", "network", false, newIPAvailabilityCollector)
+}
+
+type networkCollector struct {
+	quotaLimit           *prometheus.Desc
+	quotaUsed            *prometheus.Desc
+	perStatusFloatingIPs *prometheus.Desc
+	perStatusPorts       *prometheus.Desc
+	perStatusRouters     *prometheus.Desc
+}
+
+func newNetworkCollector() Collector {
+	return &networkCollector{
+		quotaLimit: prometheus.NewDesc("openstack_network_quota_limit",
+			"The quota limit of the network resource in the project, -1 when unlimited",
+			targetLabels("resource"), nil,
+		),
+		quotaUsed: prometheus.NewDesc("openstack_network_quota_used",
+			"The current number of the network resource used in the project",
+			targetLabels("resource"), nil,
+		),
+		perStatusFloatingIPs: prometheus.NewDesc("openstack_floating_ip_count",
+			"Number of floating IPs per status and whether they are associated to a port",
+			targetLabels("status", "associated"), nil,
+		),
+		perStatusPorts: prometheus.NewDesc("openstack_port_count",
+			"Number of ports per status and device owner",
+			targetLabels("status", "device_owner"), nil,
+		),
+		perStatusRouters: prometheus.NewDesc("openstack_router_count",
+			"Number of routers per status",
+			targetLabels("status"), nil,
+		),
+	}
+}
+
+func (collector *networkCollector) Update(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, ch chan<- prometheus.Metric) error {
+	networkClient, err := openstack.NewNetworkV2(providerClient, target.CloudConfig.EndpointOpts)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	quotaDetails, err := getNetworkQuota(ctx, networkClient, target.ProjectID())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get network quota: %w", err))
+	} else {
+		for resource, quota := range quotaDetails {
+			ch <- prometheus.MustNewConstMetric(collector.quotaLimit, prometheus.GaugeValue, float64(quota.Limit), target.labelValues(resource)...)
+			ch <- prometheus.MustNewConstMetric(collector.quotaUsed, prometheus.GaugeValue, float64(quota.Used), target.labelValues(resource)...)
+		}
+	}
+
+	floatingIPList, err := getAllFloatingIPs(ctx, networkClient, target.ProjectID())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get floating IPs: %w", err))
+	} else {
+		for key, count := range countFloatingIPPerStatus(floatingIPList) {
+			ch <- prometheus.MustNewConstMetric(collector.perStatusFloatingIPs, prometheus.GaugeValue, float64(count), target.labelValues(key.status, key.associated)...)
+		}
+	}
+
+	portList, err := getAllPorts(ctx, networkClient, target.ProjectID())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get ports: %w", err))
+	} else {
+		for key, count := range countPortPerStatus(portList) {
+			ch <- prometheus.MustNewConstMetric(collector.perStatusPorts, prometheus.GaugeValue, float64(count), target.labelValues(key.status, key.deviceOwner)...)
+		}
+	}
+
+	routerList, err := getAllRouters(ctx, networkClient, target.ProjectID())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get routers: %w", err))
+	} else {
+		for status, count := range countRouterPerStatus(routerList) {
+			ch <- prometheus.MustNewConstMetric(collector.perStatusRouters, prometheus.GaugeValue, float64(count), target.labelValues(status)...)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// getNetworkQuota returns the limit and usage of the network resources in the
+// project, keyed by the resource names Neutron uses
+func getNetworkQuota(ctx context.Context, networkClient *gophercloud.ServiceClient, projectID string) (map[string]quotas.QuotaDetail, error) {
+	level.Debug(logger).Log("message", "Getting network quota")
+
+	quotaDetails, err := quotas.GetDetail(ctx, networkClient, projectID).Extract()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]quotas.QuotaDetail{
+		"floatingip":          quotaDetails.FloatingIP,
+		"network":             quotaDetails.Network,
+		"port":                quotaDetails.Port,
+		"router":              quotaDetails.Router,
+		"security_group":      quotaDetails.SecurityGroup,
+		"security_group_rule": quotaDetails.SecurityGroupRule,
+		"subnet":              quotaDetails.Subnet,
+	}, nil
+}
+
+func getAllFloatingIPs(ctx context.Context, networkClient *gophercloud.ServiceClient, projectID string) ([]floatingips.FloatingIP, error) {
+	level.Debug(logger).Log("message", "Getting all floating IPs")
+
+	// Admins see the resources of all projects without the filter
+	allPages, err := floatingips.List(networkClient, floatingips.ListOpts{ProjectID: projectID}).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return floatingips.ExtractFloatingIPs(allPages)
+}
+
+func getAllPorts(ctx context.Context, networkClient *gophercloud.ServiceClient, projectID string) ([]ports.Port, error) {
+	level.Debug(logger).Log("message", "Getting all ports")
+
+	allPages, err := ports.List(networkClient, ports.ListOpts{ProjectID: projectID}).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return ports.ExtractPorts(allPages)
+}
+
+func getAllRouters(ctx context.Context, networkClient *gophercloud.ServiceClient, projectID string) ([]routers.Router, error) {
+	level.Debug(logger).Log("message", "Getting all routers")
+
+	allPages, err := routers.List(networkClient, routers.ListOpts{ProjectID: projectID}).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return routers.ExtractRouters(allPages)
+}
+
+type floatingIPKey struct {
+	status     string
+	associated string
+}
+
+func countFloatingIPPerStatus(floatingIPList []floatingips.FloatingIP) map[floatingIPKey]int {
+	statusCount := make(map[floatingIPKey]int)
+
+	for _, floatingIP := range floatingIPList {
+		associated := "false"
+		if floatingIP.PortID != "" {
+			associated = "true"
+		}
+		statusCount[floatingIPKey{status: floatingIP.Status, associated: associated}]++
+	}
+
+	return statusCount
+}
+
+type portKey struct {
+	status      string
+	deviceOwner string
+}
+
+func countPortPerStatus(portList []ports.Port) map[portKey]int {
+	statusCount := make(map[portKey]int)
+
+	for _, port := range portList {
+		statusCount[portKey{status: port.Status, deviceOwner: port.DeviceOwner}]++
+	}
+
+	return statusCount
+}
+
+func countRouterPerStatus(routerList []routers.Router) map[string]int {
+	statusCount := make(map[string]int)
+
+	for _, router := range routerList {
+		statusCount[router.Status]++
+	}
+
+	return statusCount
+}
+
+type ipAvailabilityCollector struct {
+	subnetIPsTotal *prometheus.Desc
+	subnetIPsUsed  *prometheus.Desc
+}
+
+func newIPAvailabilityCollector() Collector {
+	labels := targetLabels("network_id", "network_name", "subnet_id", "subnet_name", "cidr", "ip_version")
+	return &ipAvailabilityCollector{
+		subnetIPsTotal: prometheus.NewDesc("openstack_subnet_ips_total",
+			"The number of IP addresses in the allocation pools of the subnet",
+			labels, nil,
+		),
+		subnetIPsUsed: prometheus.NewDesc("openstack_subnet_ips_used",
+			"The number of IP addresses allocated in the subnet",
+			labels, nil,
+		),
+	}
+}
+
+func (collector *ipAvailabilityCollector) Update(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, ch chan<- prometheus.Metric) error {
+	networkClient, err := openstack.NewNetworkV2(providerClient, target.CloudConfig.EndpointOpts)
+	if err != nil {
+		return err
+	}
+
+	ipAvailabilities, err := getAllNetworkIPAvailabilities(ctx, networkClient, target.ProjectID())
+	if err != nil {
+		return fmt.Errorf("failed to get network IP availabilities: %w", err)
+	}
+
+	var errs []error
+	for _, network := range ipAvailabilities {
+		for _, subnet := range network.SubnetIPAvailabilities {
+			labels := target.labelValues(network.NetworkID, network.NetworkName, subnet.SubnetID, subnet.SubnetName, subnet.CIDR, strconv.Itoa(subnet.IPVersion))
+
+			// The counts are big integers as IPv6 subnets easily exceed 64 bits
+			totalIPs, err := strconv.ParseFloat(subnet.TotalIPs, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to parse total IPs of subnet %s: %w", subnet.SubnetID, err))
+				continue
+			}
+			usedIPs, err := strconv.ParseFloat(subnet.UsedIPs, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to parse used IPs of subnet %s: %w", subnet.SubnetID, err))
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(collector.subnetIPsTotal, prometheus.GaugeValue, totalIPs, labels...)
+			ch <- prometheus.MustNewConstMetric(collector.subnetIPsUsed, prometheus.GaugeValue, usedIPs, labels...)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func getAllNetworkIPAvailabilities(ctx context.Context, networkClient *gophercloud.ServiceClient, projectID string) ([]networkipavailabilities.NetworkIPAvailability, error) {
+	level.Debug(logger).Log("message", "Getting all network IP availabilities")
+
+	listOpts := networkipavailabilities.ListOpts{ProjectID: projectID}
+	allPages, err := networkipavailabilities.List(networkClient, listOpts).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return networkipavailabilities.ExtractNetworkIPAvailabilities(allPages)
+}
@@ -3,8 +3,9 @@ package internal
 import (
 	"context"
 	"encoding/json"
-	"os"
-	"strings"
+	"errors"
+	"fmt"
+	"net/http"
 
 	"github.com/go-kit/log/level"
 	"github.com/gophercloud/gophercloud/v2"
@@ -13,102 +14,151 @@ import (
 	gophertelekomcloud "github.com/opentelekomcloud/gophertelekomcloud"
 	otc "github.com/opentelekomcloud/gophertelekomcloud/openstack"
 	"github.com/opentelekomcloud/gophertelekomcloud/openstack/obs"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+func init() {
+	registerCollector("objectstorage", "objectstorage", true, newObjectStorageCollector)
+}
+
+type objectStorageCollector struct {
+	containerBytesUsed   *prometheus.Desc
+	containerObjectCount *prometheus.Desc
+}
+
+func newObjectStorageCollector() Collector {
+	return &objectStorageCollector{
+		containerBytesUsed: prometheus.NewDesc("openstack_container_bytes_used",
+			"The total of bytes stored in the container",
+			targetLabels("container"), nil,
+		),
+		containerObjectCount: prometheus.NewDesc("openstack_container_object_count",
+			"The total of objects stored in the container",
+			targetLabels("container"), nil,
+		),
+	}
+}
+
+func (collector *objectStorageCollector) Update(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, ch chan<- prometheus.Metric) error {
+	containers, err := target.Provider().Containers(ctx, providerClient, target)
+	for _, container := range containers {
+		containerBytesUsedMetric := prometheus.MustNewConstMetric(collector.containerBytesUsed, prometheus.GaugeValue, float64(container.Bytes), target.labelValues(container.Name)...)
+		containerObjectCountMetric := prometheus.MustNewConstMetric(collector.containerObjectCount, prometheus.GaugeValue, float64(container.Count), target.labelValues(container.Name)...)
+		ch <- containerBytesUsedMetric
+		ch <- containerObjectCountMetric
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get containers: %w", err)
+	}
+	return nil
+}
+
 type Container struct {
 	Bytes int64  `json:"bytes"`
 	Count int    `json:"count"`
 	Name  string `json:"name"`
 }
 
-func newOBSClient() (*obs.ObsClient, error) {
-	OSEnv := otc.NewEnv("OS_")
-	providerClient, err := OSEnv.AuthenticatedClient()
-
+func newOBSClient(cloudConfig *CloudConfig) (*obs.ObsClient, error) {
+	aksk := gophertelekomcloud.AKSKAuthOptions{
+		IdentityEndpoint: cloudConfig.AuthOptions.IdentityEndpoint,
+		ProjectId:        cloudConfig.AuthOptions.TenantID,
+		ProjectName:      cloudConfig.AuthOptions.TenantName,
+		DomainID:         cloudConfig.AuthOptions.DomainID,
+		Domain:           cloudConfig.AuthOptions.DomainName,
+		AccessKey:        cloudConfig.AccessKey,
+		SecretKey:        cloudConfig.SecretKey,
+	}
+	providerClient, err := otc.NewClient(aksk.IdentityEndpoint)
 	if err != nil {
 		return nil, err
 	}
+	if cloudConfig.TLSConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cloudConfig.TLSConfig
+		providerClient.HTTPClient.Transport = transport
+	}
+	if err := otc.Authenticate(providerClient, aksk); err != nil {
+		return nil, err
+	}
 
-	client, err := otc.NewOBSService(providerClient, gophertelekomcloud.EndpointOpts{})
+	client, err := otc.NewOBSService(providerClient, gophertelekomcloud.EndpointOpts{
+		Region: cloudConfig.EndpointOpts.Region,
+	})
 	if err != nil {
 		return nil, err
 	}
-	//opts := cc.AKSKAuthOptions
-	aksk := gophertelekomcloud.AKSKAuthOptions{
-		IdentityEndpoint: os.Getenv("OS_AUTH_URL"),
-		ProjectId:        os.Getenv("OS_PROJECT_ID"),
-		ProjectName:      os.Getenv("OS_TENANT_NAME"),
-		DomainID:         os.Getenv("OS_DOMAIN_ID"),
-		Domain:           os.Getenv("OS_DOMAIN_NAME"),
-		AccessKey:        os.Getenv("OS_ACCESS_KEY"),
-		SecretKey:        os.Getenv("OS_SECRET_KEY"),
-	}
 	return obs.New(
 		aksk.AccessKey, aksk.SecretKey, client.Endpoint,
 		obs.WithSecurityToken(aksk.SecurityToken), obs.WithSignature(obs.SignatureObs),
 	)
 }
 
-func getContainerList(providerClient *gophercloud.ProviderClient) []Container {
+func getContainerList(ctx context.Context, providerClient *gophercloud.ProviderClient, cloudConfig *CloudConfig) ([]Container, error) {
 	// Create a ObjectStorage V1 service client
-
-	var objectStorageClient *gophercloud.ServiceClient
-	var err error
-	if strings.Contains(os.Getenv("OS_AUTH_URL"), "otc") {
-		level.Debug(logger).Log("message", "Setting up OBS client")
-
-		obsClient, err := newOBSClient()
-
-		if err != nil {
-			level.Error(logger).Log("message", "Failed to setup OBS client", "err", err)
-		}
-
-		level.Debug(logger).Log("message", "Getting all containers")
-		containerList, _ := obsClient.ListBuckets(nil)
-
-		var containers []Container
-		for _, bucket := range containerList.Buckets {
-			bucketStorage, err := obsClient.GetBucketStorageInfo(bucket.Name)
-			if err != nil {
-				level.Error(logger).Log("message", "Error parsing container list", "err", err)
-			}
-			containers = append(containers, Container{Count: bucketStorage.ObjectNumber, Bytes: bucketStorage.Size, Name: bucket.Name})
-		}
-		return containers
-	} else {
-		objectStorageClient, err = openstack.NewObjectStorageV1(providerClient, gophercloud.EndpointOpts{
-			Region: os.Getenv("OS_REGION_NAME"),
-		})
-		if err != nil {
-			level.Error(logger).Log("message", "Failed to create objectstorage client", "err", err)
-		}
+	objectStorageClient, err := openstack.NewObjectStorageV1(providerClient, cloudConfig.EndpointOpts)
+	if err != nil {
+		return nil, err
 	}
 
 	listOpts := containers.ListOpts{}
 
 	level.Debug(logger).Log("message", "Getting all containers")
 
-	allPages, err := containers.List(objectStorageClient, listOpts).AllPages(context.TODO())
+	allPages, err := containers.List(objectStorageClient, listOpts).AllPages(ctx)
 	if err != nil {
-		level.Error(logger).Log("message", "Failed to retrieve all containers", "err", err)
+		return nil, err
 	}
 
 	containerList, err := containers.ExtractInfo(allPages)
 	if err != nil {
-		level.Error(logger).Log("message", "Failed to retrieve all containers", "err", err)
+		return nil, err
 	}
 
 	containerListJSON, err := json.Marshal(containerList)
-
 	if err != nil {
-		level.Error(logger).Log("message", "Error parsing container list", "err", err)
+		return nil, err
 	}
 
 	var containers []Container
 	if err := json.Unmarshal([]byte(containerListJSON), &containers); err != nil {
-		level.Error(logger).Log("message", "Error parsing JSON", "err", err)
-		return nil
+		return nil, err
 	}
 
-	return containers
+	return containers, nil
+}
+
+func getBucketList(ctx context.Context, cloudConfig *CloudConfig) ([]Container, error) {
+	level.Debug(logger).Log("message", "Setting up OBS client")
+
+	obsClient, err := newOBSClient(cloudConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	level.Debug(logger).Log("message", "Getting all containers")
+	containerList, err := obsClient.ListBuckets(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// A failing bucket does not hide the others, the buckets which were
+	// collected are returned along with the errors
+	var containers []Container
+	var errs []error
+	for _, bucket := range containerList.Buckets {
+		// The OBS client doesn't take a context, so at least stop between
+		// the requests once the scrape timed out
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		bucketStorage, err := obsClient.GetBucketStorageInfo(bucket.Name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get storage info of bucket %s: %w", bucket.Name, err))
+			continue
+		}
+		containers = append(containers, Container{Count: bucketStorage.ObjectNumber, Bytes: bucketStorage.Size, Name: bucket.Name})
+	}
+	return containers, errors.Join(errs...)
 }
@@ -0,0 +1,236 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log/level"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v2/quotasets"
+	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v2/volumes"
+	lbQuotas "github.com/gophercloud/gophercloud/v2/openstack/loadbalancer/v2/quotas"
+)
+
+var volumeLimit = kingpin.Flag("volume.limit", "Max number of volumes on OTC when no quota API reports it, unset when negative").Default("-1").Float64()
+
+var apiVersionPath = regexp.MustCompile(`/v\d+(\.\d+)?(/.*)?$`)
+
+// openTelekomCloud is the Open Telekom Cloud, which reports the volume quota
+// through EVS instead of the Cinder limits, stores objects in OBS instead of
+// Swift and balances load with ELB instead of Octavia
+type openTelekomCloud struct {
+	openStack
+}
+
+// VolumeQuota returns the volume quota from EVS, fills what is missing from
+// the resource quota API and finally from the static quota of the target
+func (openTelekomCloud) VolumeQuota(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target) (*VolumeQuota, error) {
+	volumeQuota := &VolumeQuota{}
+
+	var endpointNotFound *gophercloud.ErrEndpointNotFound
+	quotaSet, err := getEVSQuota(ctx, providerClient, target)
+	if err == nil {
+		volumeQuota = evsVolumeQuota(quotaSet)
+	} else if !errors.As(err, &endpointNotFound) {
+		return nil, err
+	}
+
+	if volumeQuota.complete() {
+		return volumeQuota, nil
+	}
+	resources, err := getOTCResourceQuota(ctx, providerClient, target)
+	if err != nil && !errors.As(err, &endpointNotFound) && !gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+		return nil, err
+	}
+	volumeQuota.applyResourceQuota(resources)
+
+	if volumeQuota.complete() {
+		return volumeQuota, nil
+	}
+	volumeQuota.applyStaticQuota(target.StaticQuota)
+	if volumeQuota.VolumesUsed != nil && volumeQuota.GigabytesUsed != nil {
+		return volumeQuota, nil
+	}
+
+	// The usage is summed up from the volumes of the project as a last resort
+	volumeList, err := getAllVolumes(ctx, providerClient, target.CloudConfig)
+	if err != nil {
+		return nil, err
+	}
+	volumeQuota.applyVolumeUsage(volumeList)
+	return volumeQuota, nil
+}
+
+func (openTelekomCloud) Containers(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target) ([]Container, error) {
+	return getBucketList(ctx, target.CloudConfig)
+}
+
+// LoadBalancerClient returns a client for the shared ELB, which serves the
+// Octavia compatible API under /v2.0 of the ELB endpoint
+func (openTelekomCloud) LoadBalancerClient(providerClient *gophercloud.ProviderClient, target *Target) (*gophercloud.ServiceClient, error) {
+	eo := target.CloudConfig.EndpointOpts
+	eo.ApplyDefaults("elb")
+	endpoint, err := providerClient.EndpointLocator(eo)
+	if err != nil {
+		return nil, err
+	}
+	// The catalog might point to the versioned API of the classic load
+	// balancers, like /v1.0/{project_id}
+	if loc := apiVersionPath.FindStringIndex(endpoint); loc != nil {
+		endpoint = endpoint[:loc[0]+1]
+	}
+	return &gophercloud.ServiceClient{
+		ProviderClient: providerClient,
+		Endpoint:       endpoint,
+		ResourceBase:   endpoint + "v2.0/",
+		Type:           "elb",
+	}, nil
+}
+
+// LoadBalancerQuota returns the quota of the project from ELB, which only
+// serves the quota of the current project
+func (openTelekomCloud) LoadBalancerQuota(ctx context.Context, lbClient *gophercloud.ServiceClient, target *Target) (*lbQuotas.Quota, error) {
+	level.Debug(logger).Log("message", "Getting ELB quota")
+
+	var result lbQuotas.GetResult
+	_, result.Err = lbClient.Get(ctx, lbClient.ServiceURL("lbaas", "quotas"), &result.Body, nil)
+	return result.Extract()
+}
+
+// getEVSQuota returns the quota and usage of the project from the
+// Cinder-compatible quota sets of EVS
+func getEVSQuota(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target) (*quotasets.QuotaUsageSet, error) {
+	eo := target.CloudConfig.EndpointOpts
+	eo.ApplyDefaults("evs")
+	endpoint, err := providerClient.EndpointLocator(eo)
+	if err != nil {
+		return nil, err
+	}
+	evsClient := &gophercloud.ServiceClient{
+		ProviderClient: providerClient,
+		Endpoint:       endpoint,
+		Type:           "evs",
+	}
+
+	level.Debug(logger).Log("message", "Getting EVS quota")
+
+	quotaSet, err := quotasets.GetUsage(ctx, evsClient, target.ProjectID()).Extract()
+	if err != nil {
+		return nil, err
+	}
+	return &quotaSet, nil
+}
+
+// otcResourceQuota is the quota and usage of a resource type in the resource
+// quota API of OTC
+type otcResourceQuota struct {
+	Type  string `json:"type"`
+	Used  int    `json:"used"`
+	Quota int    `json:"quota"`
+}
+
+// getOTCResourceQuota returns the quotas of the project from the resource
+// quota API, which is served under /v1.0/{project_id}/quotas of the ECS
+// endpoint
+func getOTCResourceQuota(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target) ([]otcResourceQuota, error) {
+	eo := target.CloudConfig.EndpointOpts
+	eo.ApplyDefaults("ecs")
+	endpoint, err := providerClient.EndpointLocator(eo)
+	if err != nil {
+		return nil, err
+	}
+	if loc := apiVersionPath.FindStringIndex(endpoint); loc != nil {
+		endpoint = endpoint[:loc[0]+1]
+	}
+	quotaClient := &gophercloud.ServiceClient{
+		ProviderClient: providerClient,
+		Endpoint:       endpoint,
+		ResourceBase:   endpoint + "v1.0/",
+		Type:           "ecs",
+	}
+
+	level.Debug(logger).Log("message", "Getting OTC resource quota")
+
+	var result struct {
+		Quotas struct {
+			Resources []otcResourceQuota `json:"resources"`
+		} `json:"quotas"`
+	}
+	_, err = quotaClient.Get(ctx, quotaClient.ServiceURL(target.ProjectID(), "quotas"), &result, nil)
+	if err != nil {
+		return nil, err
+	}
+	return result.Quotas.Resources, nil
+}
+
+// evsVolumeQuota returns the volume quota of an EVS quota set, which always
+// reports all limits and usages
+func evsVolumeQuota(quotaSet *quotasets.QuotaUsageSet) *VolumeQuota {
+	maxVolumes := float64(quotaSet.Volumes.Limit)
+	maxGigabytes := float64(quotaSet.Gigabytes.Limit)
+	volumesUsed := float64(quotaSet.Volumes.InUse)
+	gigabytesUsed := float64(quotaSet.Gigabytes.InUse)
+	return &VolumeQuota{
+		MaxVolumes:    &maxVolumes,
+		MaxGigabytes:  &maxGigabytes,
+		VolumesUsed:   &volumesUsed,
+		GigabytesUsed: &gigabytesUsed,
+	}
+}
+
+// applyResourceQuota fills the limits and usages which are not known yet from
+// the resource quota API
+func (q *VolumeQuota) applyResourceQuota(resources []otcResourceQuota) {
+	for _, resource := range resources {
+		quota, used := float64(resource.Quota), float64(resource.Used)
+		switch resource.Type {
+		case "volumes":
+			q.MaxVolumes = coalesceFloat(q.MaxVolumes, &quota)
+			q.VolumesUsed = coalesceFloat(q.VolumesUsed, &used)
+		case "gigabytes":
+			q.MaxGigabytes = coalesceFloat(q.MaxGigabytes, &quota)
+			q.GigabytesUsed = coalesceFloat(q.GigabytesUsed, &used)
+		}
+	}
+}
+
+// applyStaticQuota fills the limits which are not known yet with the static
+// quota of the target, the limit of volumes defaults to --volume.limit
+func (q *VolumeQuota) applyStaticQuota(staticQuota StaticQuota) {
+	q.MaxVolumes = coalesceFloat(q.MaxVolumes, staticQuota.Volumes)
+	q.MaxGigabytes = coalesceFloat(q.MaxGigabytes, staticQuota.Gigabytes)
+	if q.MaxVolumes == nil && *volumeLimit >= 0 {
+		limit := *volumeLimit
+		q.MaxVolumes = &limit
+	}
+}
+
+// applyVolumeUsage fills the usages which are not known yet with the number
+// and size of the volumes
+func (q *VolumeQuota) applyVolumeUsage(volumeList []volumes.Volume) {
+	volumesUsed := float64(len(volumeList))
+	gigabytesUsed := 0.0
+	for _, volume := range volumeList {
+		gigabytesUsed += float64(volume.Size)
+	}
+	q.VolumesUsed = coalesceFloat(q.VolumesUsed, &volumesUsed)
+	q.GigabytesUsed = coalesceFloat(q.GigabytesUsed, &gigabytesUsed)
+}
+
+// complete tells whether all limits and usages of the quota are known
+func (q *VolumeQuota) complete() bool {
+	return q.MaxVolumes != nil && q.MaxGigabytes != nil && q.VolumesUsed != nil && q.GigabytesUsed != nil
+}
+
+// coalesceFloat returns the first value which is set
+func coalesceFloat(values ...*float64) *float64 {
+	for _, value := range values {
+		if value != nil {
+			return value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v2/quotasets"
+	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v2/volumes"
+)
+
+func TestMergeVolumeQuota(t *testing.T) {
+	limit := *volumeLimit
+	defer func() { *volumeLimit = limit }()
+
+	float := func(value float64) *float64 { return &value }
+	volumeList := []volumes.Volume{{ID: "v1", Size: 10}, {ID: "v2", Size: 30}}
+
+	tests := []struct {
+		name        string
+		quotaSet    *quotasets.QuotaUsageSet
+		resources   []otcResourceQuota
+		staticQuota StaticQuota
+		volumeLimit float64
+		expected    [4]*float64
+	}{
+		{
+			name: "EVS complete",
+			quotaSet: &quotasets.QuotaUsageSet{
+				Volumes:   quotasets.QuotaUsage{Limit: 100, InUse: 2},
+				Gigabytes: quotasets.QuotaUsage{Limit: 1000, InUse: 40},
+			},
+			resources:   []otcResourceQuota{{Type: "volumes", Used: 5, Quota: 50}},
+			staticQuota: StaticQuota{Volumes: float(10), Gigabytes: float(100)},
+			volumeLimit: 20,
+			expected:    [4]*float64{float(100), float(1000), float(2), float(40)},
+		},
+		{
+			name:        "EVS missing with a partial resource quota",
+			resources:   []otcResourceQuota{{Type: "volumes", Used: 5, Quota: 50}, {Type: "instances", Used: 1, Quota: 10}},
+			staticQuota: StaticQuota{Volumes: float(10), Gigabytes: float(100)},
+			volumeLimit: 20,
+			expected:    [4]*float64{float(50), float(100), float(5), float(40)},
+		},
+		{
+			name:        "everything missing with --volume.limit",
+			volumeLimit: 20,
+			expected:    [4]*float64{float(20), nil, float(2), float(40)},
+		},
+		{
+			name:        "everything missing without --volume.limit",
+			volumeLimit: -1,
+			expected:    [4]*float64{nil, nil, float(2), float(40)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			*volumeLimit = test.volumeLimit
+
+			// The stages only fill what is still missing, so applying all of
+			// them gives the same quota as stopping once it is complete
+			volumeQuota := &VolumeQuota{}
+			if test.quotaSet != nil {
+				volumeQuota = evsVolumeQuota(test.quotaSet)
+			}
+			volumeQuota.applyResourceQuota(test.resources)
+			volumeQuota.applyStaticQuota(test.staticQuota)
+			volumeQuota.applyVolumeUsage(volumeList)
+
+			fields := []string{"max volumes", "max gigabytes", "volumes used", "gigabytes used"}
+			for i, value := range []*float64{volumeQuota.MaxVolumes, volumeQuota.MaxGigabytes, volumeQuota.VolumesUsed, volumeQuota.GigabytesUsed} {
+				expected := test.expected[i]
+				switch {
+				case expected == nil && value != nil:
+					t.Errorf("expected no %s, got %v", fields[i], *value)
+				case expected != nil && value == nil:
+					t.Errorf("expected %s %v, got none", fields[i], *expected)
+				case expected != nil && *value != *expected:
+					t.Errorf("expected %s %v, got %v", fields[i], *expected, *value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// Poller collects the targets in the background with all enabled collectors,
+// so scrapes are served from the latest snapshots instead of querying the
+// OpenStack APIs. Any number of Prometheus servers can then scrape the
+// exporter without adding load on the APIs.
+type Poller struct {
+	targets    []*Target
+	collectors map[string]Collector
+	interval   time.Duration
+	timeout    time.Duration
+
+	mu        sync.RWMutex
+	snapshots map[*Target]*targetSnapshot
+}
+
+// NewPoller creates a poller collecting the targets every interval. A
+// collection is cancelled after the timeout, unless it is 0.
+func NewPoller(targets []*Target, interval, timeout time.Duration) (*Poller, error) {
+	collectors, err := enabledCollectors()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Poller{
+		targets:    targets,
+		collectors: collectors,
+		interval:   interval,
+		timeout:    timeout,
+		snapshots:  make(map[*Target]*targetSnapshot),
+	}, nil
+}
+
+// Start polls every collector of every target in its own goroutine, so a slow
+// service does not delay the snapshots of the others
+func (p *Poller) Start() {
+	for _, target := range p.targets {
+		for name, c := range p.collectors {
+			go p.poll(target, name, c)
+		}
+	}
+}
+
+// poll refreshes the snapshot of the collector every interval. The collections
+// of a collector run one after the other, when one takes longer than the
+// interval the next one starts right after it.
+func (p *Poller) poll(target *Target, name string, c Collector) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.refresh(target, name, c)
+		<-ticker.C
+	}
+}
+
+func (p *Poller) refresh(target *Target, name string, c Collector) {
+	defer func() {
+		if r := recover(); r != nil {
+			level.Error(logger).Log("message", "Metrics collection failed", "target", target.Name, "collector", name, "err", r)
+		}
+	}()
+
+	ctx := context.Background()
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	var result collectorResult
+	providerClient, authErr := target.ProviderClient(ctx)
+	if authErr != nil {
+		level.Error(logger).Log("message", "Failed to authenticate to OpenStack API", "target", target.Name, "err", authErr)
+		result = collectorResult{err: authErr}
+	} else {
+		result = collect(ctx, target, name, c, providerClient)
+	}
+
+	p.update(target, name, result, authErr)
+}
+
+// update replaces the snapshot of the target with a copy holding the new
+// result, as the current one might still be read by a scrape
+func (p *Poller) update(target *Target, name string, result collectorResult, authErr error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	snapshot := &targetSnapshot{
+		authErr: authErr,
+		results: map[string]collectorResult{name: result},
+	}
+	if previous, ok := p.snapshots[target]; ok {
+		for previousName, previousResult := range previous.results {
+			if previousName != name {
+				snapshot.results[previousName] = previousResult
+			}
+		}
+	}
+	p.snapshots[target] = snapshot
+}
+
+// snapshot returns the latest snapshot of the target, or nil when no
+// collection finished yet
+func (p *Poller) snapshot(target *Target) *targetSnapshot {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.snapshots[target]
+}
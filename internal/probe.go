@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type probeHandler struct {
+	modules       map[string]Module
+	timeoutOffset time.Duration
+
+	mu      sync.Mutex
+	targets map[string]*Target
+}
+
+// NewProbeHandler returns a handler which collects a single target per request,
+// selected with the target parameter. The target is either one of the
+// configured targets or an entry in clouds.yaml. The module parameter selects
+// the set of collectors to run.
+func NewProbeHandler(targets []*Target, modules map[string]Module, timeoutOffset time.Duration) http.Handler {
+	handler := &probeHandler{
+		modules:       modules,
+		timeoutOffset: timeoutOffset,
+		targets:       make(map[string]*Target),
+	}
+	for _, target := range targets {
+		handler.targets[target.Name] = target
+	}
+	return handler
+}
+
+func (h *probeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	targetName := params.Get("target")
+	if targetName == "" {
+		http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	var collectors []string
+	if moduleName := params.Get("module"); moduleName != "" {
+		module, ok := h.modules[moduleName]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown module %q", moduleName), http.StatusBadRequest)
+			return
+		}
+		collectors = module.Collectors
+	}
+
+	target, err := h.getTarget(targetName)
+	if err != nil {
+		level.Error(logger).Log("message", "Failed to load target", "target", targetName, "err", err)
+		http.Error(w, fmt.Sprintf("Unknown target %q", targetName), http.StatusBadRequest)
+		return
+	}
+
+	collector, err := NewOpenStackCollector([]*Target{target}, nil, scrapeTimeout(r, h.timeoutOffset), collectors...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Couldn't create collector: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// getTarget returns the configured target with the given name, or loads it
+// from clouds.yaml on first use
+func (h *probeHandler) getTarget(name string) (*Target, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if target, ok := h.targets[name]; ok {
+		return target, nil
+	}
+
+	target, err := NewTarget(TargetConfig{Name: name, Cloud: name})
+	if err != nil {
+		return nil, err
+	}
+	h.targets[name] = target
+	return target, nil
+}
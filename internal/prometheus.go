@@ -1,226 +1,293 @@
 package internal
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/log/level"
+	"github.com/gophercloud/gophercloud/v2"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 type openStackCollector struct {
-	collectDuration *prometheus.Desc
-	// Compute metrics
-	maxTotalCores          *prometheus.Desc
-	maxTotalInstances      *prometheus.Desc
-	maxTotalRAMSize        *prometheus.Desc
-	perFlavorInstanceCount *prometheus.Desc
-	perStatusInstanceCount *prometheus.Desc
-	totalCoresUsed         *prometheus.Desc
-	totalInstancesUsed     *prometheus.Desc
-	totalRAMUsed           *prometheus.Desc
-	// Volume metrics
-	containerBytesUsed      *prometheus.Desc
-	containerObjectCount    *prometheus.Desc
-	maxTotalVolumeGigabytes *prometheus.Desc
-	maxTotalVolumes         *prometheus.Desc
-	perStatusVolumeCount    *prometheus.Desc
-	totalGigabytesUsed      *prometheus.Desc
-	totalVolumesUsed        *prometheus.Desc
-	volumeLimit             float64
+	collectDuration   *prometheus.Desc
+	collectorDuration *prometheus.Desc
+	collectorSuccess  *prometheus.Desc
+	up                *prometheus.Desc
+	// Authentication metrics
+	authAttempts             *prometheus.Desc
+	authCredentialExpiry     *prometheus.Desc
+	authTokenExpiry          *prometheus.Desc
+	lastSuccessfulCollection *prometheus.Desc
+	targets                  []*Target
+	collectors               map[string]Collector
+	poller                   *Poller
+	timeout                  time.Duration
 }
 
-func NewOpenStackCollector(volumeLimit float64) *openStackCollector {
+// NewOpenStackCollector creates a collector for the given targets, running
+// only the collectors named in filters or all enabled ones when none are given.
+// A collection is cancelled after the timeout, unless it is zero. With a
+// poller the metrics are taken from its latest snapshot instead.
+func NewOpenStackCollector(targets []*Target, poller *Poller, timeout time.Duration, filters ...string) (*openStackCollector, error) {
+	collectors, err := enabledCollectors(filters...)
+	if err != nil {
+		return nil, err
+	}
+
 	return &openStackCollector{
 		collectDuration: prometheus.NewDesc("openstack_collect_duration_seconds",
 			"The time it took to collect the metrics in seconds",
-			nil, nil,
-		),
-		// Compute metrics
-		maxTotalCores: prometheus.NewDesc("openstack_max_total_cores",
-			"The limit of cores that can be assigned to instances in the project",
-			nil, nil,
-		),
-		maxTotalInstances: prometheus.NewDesc("openstack_max_total_instances",
-			"The limit of total instances in the project",
-			nil, nil,
-		),
-		maxTotalRAMSize: prometheus.NewDesc("openstack_max_total_ram_size",
-			"The limit of RAM that can be assigned to instances in the project",
-			nil, nil,
-		),
-		perFlavorInstanceCount: prometheus.NewDesc("openstack_per_flavor_instance_count",
-			"Number of instances per flavor",
-			[]string{"flavor"}, nil,
-		),
-		perStatusInstanceCount: prometheus.NewDesc("openstack_per_status_instance_count",
-			"Number of instances per status",
-			[]string{"status"}, nil,
+			targetLabels(), nil,
 		),
-		totalCoresUsed: prometheus.NewDesc("openstack_total_cores_used",
-			"The current number of cores used",
-			nil, nil,
+		collectorDuration: prometheus.NewDesc("openstack_collector_duration_seconds",
+			"The time it took to run a collector in seconds",
+			targetLabels("collector"), nil,
 		),
-		totalInstancesUsed: prometheus.NewDesc("openstack_total_instances_used",
-			"The current number of instances",
-			nil, nil,
+		collectorSuccess: prometheus.NewDesc("openstack_collector_success",
+			"Whether a collector succeeded",
+			targetLabels("collector"), nil,
 		),
-		totalRAMUsed: prometheus.NewDesc("openstack_total_ram_used",
-			"The current number RAM used",
-			nil, nil,
+		up: prometheus.NewDesc("openstack_up",
+			"Whether the last requests to the service were successful",
+			targetLabels("service"), nil,
 		),
-		// Volume metrics
-		containerBytesUsed: prometheus.NewDesc("openstack_container_bytes_used",
-			"The total of bytes stored in the container",
-			[]string{"container"}, nil,
+		// Authentication metrics
+		authAttempts: prometheus.NewDesc("openstack_auth_attempts_total",
+			"The number of authentications to the OpenStack API by result",
+			targetLabels("result"), nil,
 		),
-		maxTotalVolumeGigabytes: prometheus.NewDesc("openstack_max_total_volume_gigabytes",
-			"The limit of total volume size in the project",
-			nil, nil,
+		authCredentialExpiry: prometheus.NewDesc("openstack_auth_credential_expiry_timestamp_seconds",
+			"The time the application credential or pre-issued token expires in seconds since epoch",
+			targetLabels(), nil,
 		),
-		maxTotalVolumes: prometheus.NewDesc("openstack_max_total_volumes",
-			"The limit of total volumes in the project",
-			nil, nil,
+		authTokenExpiry: prometheus.NewDesc("openstack_auth_token_expiry_timestamp_seconds",
+			"The time the current token expires in seconds since epoch",
+			targetLabels(), nil,
 		),
-		perStatusVolumeCount: prometheus.NewDesc("openstack_per_status_volume_count",
-			"Number of volumes per status",
-			[]string{"status"}, nil,
+		lastSuccessfulCollection: prometheus.NewDesc("openstack_last_successful_collection_timestamp_seconds",
+			"The time a collector last succeeded in seconds since epoch",
+			targetLabels("collector"), nil,
 		),
-		totalGigabytesUsed: prometheus.NewDesc("openstack_total_volume_gigabytes_used",
-			"The current total of gigabytes used in volumes",
-			nil, nil,
-		),
-		totalVolumesUsed: prometheus.NewDesc("openstack_total_volumes_used",
-			"The current number of volumes",
-			nil, nil,
-		),
-		volumeLimit: volumeLimit,
-		containerObjectCount: prometheus.NewDesc("openstack_container_object_count",
-			"The total of objects stored in the container",
-			[]string{"container"}, nil,
-		),
-	}
+		targets:    targets,
+		collectors: collectors,
+		poller:     poller,
+		timeout:    timeout,
+	}, nil
 }
 
 func (c *openStackCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.collectDuration
-	// Compute metrics
-	ch <- c.maxTotalCores
-	ch <- c.maxTotalInstances
-	ch <- c.maxTotalRAMSize
-	ch <- c.perFlavorInstanceCount
-	ch <- c.perStatusInstanceCount
-	ch <- c.totalCoresUsed
-	ch <- c.totalInstancesUsed
-	ch <- c.totalRAMUsed
-	// Volume metrics
-	ch <- c.containerBytesUsed
-	ch <- c.maxTotalVolumeGigabytes
-	ch <- c.maxTotalVolumes
-	ch <- c.totalGigabytesUsed
-	ch <- c.totalVolumesUsed
-	ch <- c.containerObjectCount
+	ch <- c.collectorDuration
+	ch <- c.collectorSuccess
+	ch <- c.up
+	ch <- c.lastSuccessfulCollection
+	// Authentication metrics
+	ch <- c.authAttempts
+	ch <- c.authCredentialExpiry
+	ch <- c.authTokenExpiry
 }
 
 func (collector *openStackCollector) Collect(ch chan<- prometheus.Metric) {
-	level.Info(logger).Log("message", "Starting metrics collection")
-	startTime := time.Now()
+	ctx := context.Background()
+	if collector.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, collector.timeout)
+		defer cancel()
+	}
 
-	defer func() {
-		duration := time.Since(startTime).Seconds()
-		level.Debug(logger).Log("message", fmt.Sprintf("Metrics collection duration: %f seconds", duration))
-		ch <- prometheus.MustNewConstMetric(collector.collectDuration, prometheus.GaugeValue, duration)
-	}()
-	providerClient, err := authenticateOpenStack()
+	// Targets are collected independently, so a failing target does not
+	// block the others
+	var wg sync.WaitGroup
+	for _, target := range collector.targets {
+		wg.Add(1)
+		go func(target *Target) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					level.Error(logger).Log("message", "Metrics collection failed", "target", target.Name, "err", r)
+				}
+			}()
+			collector.collectTarget(ctx, target, ch)
+		}(target)
+	}
+	wg.Wait()
+}
 
-	if err != nil {
-		level.Error(logger).Log("message", "Failed to authenticate to OpenStack API", "err", err)
+func (collector *openStackCollector) collectTarget(ctx context.Context, target *Target, ch chan<- prometheus.Metric) {
+	var snapshot *targetSnapshot
+	if collector.poller != nil {
+		snapshot = collector.poller.snapshot(target)
+	} else {
+		snapshot = collectSnapshot(ctx, target, collector.collectors)
+		ch <- prometheus.MustNewConstMetric(collector.collectDuration, prometheus.GaugeValue, snapshot.duration.Seconds(), target.labelValues()...)
+	}
+	collector.collectAuth(target, ch)
+
+	// Nothing was collected yet when polling just started
+	if snapshot == nil {
 		return
 	}
 
-	computeLimits := getComputeLimits(providerClient)
-	maxTotalCores := float64(computeLimits.Absolute.MaxTotalCores)
-	maxTotalInstances := float64(computeLimits.Absolute.MaxTotalInstances)
-	maxTotalRAMSize := float64(computeLimits.Absolute.MaxTotalRAMSize)
-	totalCoresUsed := float64(computeLimits.Absolute.TotalCoresUsed)
-	totalInstancesUsed := float64(computeLimits.Absolute.TotalInstancesUsed)
-	totalRAMUsed := float64(computeLimits.Absolute.TotalRAMUsed)
-
-	maxTotalCoresMetric := prometheus.MustNewConstMetric(collector.maxTotalCores, prometheus.GaugeValue, maxTotalCores)
-	maxTotalInstancesMetric := prometheus.MustNewConstMetric(collector.maxTotalInstances, prometheus.GaugeValue, maxTotalInstances)
-	maxTotalRAMSizeMetric := prometheus.MustNewConstMetric(collector.maxTotalRAMSize, prometheus.GaugeValue, maxTotalRAMSize)
-	totalCoresUsedMetric := prometheus.MustNewConstMetric(collector.totalCoresUsed, prometheus.GaugeValue, totalCoresUsed)
-	totalInstancesUsedMetric := prometheus.MustNewConstMetric(collector.totalInstancesUsed, prometheus.GaugeValue, totalInstancesUsed)
-	totalRAMUsedMetric := prometheus.MustNewConstMetric(collector.totalRAMUsed, prometheus.GaugeValue, totalRAMUsed)
-
-	serverList := getAllServers(providerClient)
-	flavorCount := countInstancePerFlavor(serverList)
-	for flavor, count := range flavorCount {
-		flavorCountMetric := prometheus.MustNewConstMetric(collector.perFlavorInstanceCount, prometheus.GaugeValue, float64(count), flavor)
-		ch <- flavorCountMetric
+	identityUp := 1.0
+	if snapshot.authErr != nil {
+		identityUp = 0
 	}
+	ch <- prometheus.MustNewConstMetric(collector.up, prometheus.GaugeValue, identityUp, target.labelValues("identity")...)
 
-	statusCountServers := countInstancePerStatus(serverList)
-	for status, count := range statusCountServers {
-		statusCountMetric := prometheus.MustNewConstMetric(collector.perStatusInstanceCount, prometheus.GaugeValue, float64(count), status)
-		ch <- statusCountMetric
+	// Several collectors can talk to the same service, which is only up when
+	// all of them succeeded
+	serviceUp := make(map[string]float64)
+	for name := range collector.collectors {
+		collector.collectLastCollection(target, name, ch)
+
+		result, ok := snapshot.results[name]
+		if !ok {
+			// None of the collectors can run without a token
+			if snapshot.authErr != nil {
+				ch <- prometheus.MustNewConstMetric(collector.collectorSuccess, prometheus.GaugeValue, 0, target.labelValues(name)...)
+			}
+			continue
+		}
+		for _, metric := range result.metrics {
+			ch <- metric
+		}
+
+		success := 1.0
+		if result.err != nil {
+			success = 0
+		}
+		ch <- prometheus.MustNewConstMetric(collector.collectorDuration, prometheus.GaugeValue, result.duration.Seconds(), target.labelValues(name)...)
+		ch <- prometheus.MustNewConstMetric(collector.collectorSuccess, prometheus.GaugeValue, success, target.labelValues(name)...)
+
+		service := collectorServices[name]
+		if up, ok := serviceUp[service]; !ok || up > success {
+			serviceUp[service] = success
+		}
+	}
+	for service, up := range serviceUp {
+		ch <- prometheus.MustNewConstMetric(collector.up, prometheus.GaugeValue, up, target.labelValues(service)...)
 	}
+}
 
-	volumeList := getAllVolumes(providerClient)
-	statusCountVolumes := countVolumePerStatus(volumeList)
-	for status, count := range statusCountVolumes {
-		statusCountMetric := prometheus.MustNewConstMetric(collector.perStatusVolumeCount, prometheus.GaugeValue, float64(count), status)
-		ch <- statusCountMetric
+func (collector *openStackCollector) collectAuth(target *Target, ch chan<- prometheus.Metric) {
+	authStats := target.getAuthStats()
+	ch <- prometheus.MustNewConstMetric(collector.authAttempts, prometheus.CounterValue, float64(authStats.successes), target.labelValues("success")...)
+	ch <- prometheus.MustNewConstMetric(collector.authAttempts, prometheus.CounterValue, float64(authStats.failures), target.labelValues("failure")...)
+	if !authStats.tokenExpiry.IsZero() {
+		ch <- prometheus.MustNewConstMetric(collector.authTokenExpiry, prometheus.GaugeValue, float64(authStats.tokenExpiry.Unix()), target.labelValues()...)
 	}
+	if !authStats.credentialExpiry.IsZero() {
+		ch <- prometheus.MustNewConstMetric(collector.authCredentialExpiry, prometheus.GaugeValue, float64(authStats.credentialExpiry.Unix()), target.labelValues()...)
+	}
+}
 
-	if !strings.Contains(os.Getenv("OS_AUTH_URL"), "otc") {
-
-		volumeLimits, err := getVolumeLimits(providerClient)
-		if err != nil {
-			level.Error(logger).Log("message", "Failed to get volume limits", "err", err)
-			panic("Was not able to get limits if api has changed it requires changes to the exporter.")
-		} else {
-			maxTotalVolumeGigabytes := float64(volumeLimits.Absolute.MaxTotalVolumeGigabytes)
-			maxTotalVolumes := float64(volumeLimits.Absolute.MaxTotalVolumes)
-			totalGigabytesUsed := float64(volumeLimits.Absolute.TotalGigabytesUsed)
-			totalVolumesUsed := float64(volumeLimits.Absolute.TotalVolumesUsed)
-	
-			maxTotalVolumeGigabytesMetric := prometheus.MustNewConstMetric(collector.maxTotalVolumeGigabytes, prometheus.GaugeValue, maxTotalVolumeGigabytes)
-			maxTotalVolumesMetric := prometheus.MustNewConstMetric(collector.maxTotalVolumes, prometheus.GaugeValue, maxTotalVolumes)
-			totalGigabytesUsedMetric := prometheus.MustNewConstMetric(collector.totalGigabytesUsed, prometheus.GaugeValue, totalGigabytesUsed)
-			totalVolumesUsedMetric := prometheus.MustNewConstMetric(collector.totalVolumesUsed, prometheus.GaugeValue, totalVolumesUsed)
-			// Volume metrics
-			ch <- maxTotalVolumeGigabytesMetric
-			ch <- maxTotalVolumesMetric
-			ch <- totalGigabytesUsedMetric
-			ch <- totalVolumesUsedMetric
-		}
-	} else {
-		totalVolumesUsed := float64(len(volumeList))
-		maxTotalVolumes := float64(collector.volumeLimit)
-		maxTotalVolumesMetric := prometheus.MustNewConstMetric(collector.maxTotalVolumes, prometheus.GaugeValue, maxTotalVolumes)
-		totalVolumesUsedMetric := prometheus.MustNewConstMetric(collector.totalVolumesUsed, prometheus.GaugeValue, totalVolumesUsed)
-		ch <- maxTotalVolumesMetric
-		ch <- totalVolumesUsedMetric
+func (collector *openStackCollector) collectLastCollection(target *Target, name string, ch chan<- prometheus.Metric) {
+	if timestamp, ok := target.getLastCollection(name); ok {
+		ch <- prometheus.MustNewConstMetric(collector.lastSuccessfulCollection, prometheus.GaugeValue, float64(timestamp.Unix()), target.labelValues(name)...)
+	}
+}
+
+// targetSnapshot holds the outcome of collecting a target. It is never
+// modified after it was created, so it can be shared between scrapes.
+type targetSnapshot struct {
+	duration time.Duration
+	authErr  error
+	results  map[string]collectorResult
+}
+
+// collectorResult holds the metrics a collector returned and whether it failed
+type collectorResult struct {
+	metrics  []prometheus.Metric
+	err      error
+	duration time.Duration
+}
+
+// collectSnapshot runs the collectors against the target. The collectors talk
+// to different services, so they run in parallel and a slow service only
+// delays its own metrics.
+func collectSnapshot(ctx context.Context, target *Target, collectors map[string]Collector) *targetSnapshot {
+	level.Info(logger).Log("message", "Starting metrics collection", "target", target.Name)
+	startTime := time.Now()
+
+	snapshot := &targetSnapshot{results: make(map[string]collectorResult)}
+	defer func() {
+		snapshot.duration = time.Since(startTime)
+		level.Debug(logger).Log("message", fmt.Sprintf("Metrics collection duration: %f seconds", snapshot.duration.Seconds()), "target", target.Name)
+	}()
+
+	providerClient, err := target.ProviderClient(ctx)
+	if err != nil {
+		level.Error(logger).Log("message", "Failed to authenticate to OpenStack API", "target", target.Name, "err", err)
+		snapshot.authErr = err
+		return snapshot
 	}
 
-	containers := getContainerList(providerClient)
-	for _, container := range containers {
-		containerBytesUsedMetric := prometheus.MustNewConstMetric(collector.containerBytesUsed, prometheus.GaugeValue, float64(container.Bytes), container.Name)
-		containerObjectCountMetric := prometheus.MustNewConstMetric(collector.containerObjectCount, prometheus.GaugeValue, float64(container.Count), container.Name)
-		ch <- containerBytesUsedMetric
-		ch <- containerObjectCountMetric
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, c := range collectors {
+		wg.Add(1)
+		go func(name string, c Collector) {
+			defer wg.Done()
+			result := collect(ctx, target, name, c, providerClient)
+
+			mu.Lock()
+			defer mu.Unlock()
+			snapshot.results[name] = result
+		}(name, c)
 	}
+	wg.Wait()
+
+	level.Info(logger).Log("message", "Finished metrics collection", "target", target.Name)
+	return snapshot
+}
 
-	// Compute metrics
-	ch <- maxTotalCoresMetric
-	ch <- maxTotalInstancesMetric
-	ch <- maxTotalRAMSizeMetric
-	ch <- totalCoresUsedMetric
-	ch <- totalInstancesUsedMetric
-	ch <- totalRAMUsedMetric
+// collect runs a single collector against the target
+func collect(ctx context.Context, target *Target, name string, c Collector, providerClient *gophercloud.ProviderClient) collectorResult {
+	startTime := time.Now()
+	metrics, err := runCollector(ctx, c, providerClient, target)
+	duration := time.Since(startTime)
 
-	level.Info(logger).Log("message", "Finished metrics collection")
+	if err != nil {
+		level.Error(logger).Log("message", "Collector failed", "target", target.Name, "collector", name, "err", err)
+	} else {
+		target.recordCollection(name, time.Now())
+	}
+	return collectorResult{metrics: metrics, err: err, duration: duration}
+}
+
+// runCollector returns the metrics of the collector once it finished or ctx is
+// done. A collector which overran is reported as failed with the metrics it
+// sent so far, whatever it still sends is dropped.
+func runCollector(ctx context.Context, c Collector, providerClient *gophercloud.ProviderClient, target *Target) ([]prometheus.Metric, error) {
+	metrics := make(chan prometheus.Metric)
+	result := make(chan error, 1)
+	go func() {
+		defer close(metrics)
+		defer func() {
+			if r := recover(); r != nil {
+				result <- fmt.Errorf("collector panicked: %v", r)
+			}
+		}()
+		result <- c.Update(ctx, providerClient, target, metrics)
+	}()
+
+	var collected []prometheus.Metric
+	for {
+		select {
+		case metric, ok := <-metrics:
+			if !ok {
+				return collected, <-result
+			}
+			collected = append(collected, metric)
+		case <-ctx.Done():
+			go func() {
+				for range metrics {
+				}
+			}()
+			return collected, ctx.Err()
+		}
+	}
 }
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fakeCollector sends its metrics and, if blocking, waits for ctx to be done
+type fakeCollector struct {
+	metrics  int
+	blocking bool
+}
+
+var fakeDesc = prometheus.NewDesc("openstack_fake", "A metric of the fake collector", nil, nil)
+
+func (c *fakeCollector) Update(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, ch chan<- prometheus.Metric) error {
+	for i := 0; i < c.metrics; i++ {
+		ch <- prometheus.MustNewConstMetric(fakeDesc, prometheus.GaugeValue, float64(i))
+	}
+	if c.blocking {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func TestRunCollectorTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	metrics, err := runCollector(ctx, &fakeCollector{metrics: 2, blocking: true}, &gophercloud.ProviderClient{}, newTestTarget("a"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	if len(metrics) != 2 {
+		t.Errorf("expected the 2 metrics sent before the timeout, got %d", len(metrics))
+	}
+}
+
+func TestCollectSnapshotTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	target := newTestTarget("a")
+	target.providerClient = &gophercloud.ProviderClient{}
+	snapshot := collectSnapshot(ctx, target, map[string]Collector{
+		"slow": &fakeCollector{metrics: 1, blocking: true},
+		"fast": &fakeCollector{metrics: 3},
+	})
+
+	if snapshot.authErr != nil {
+		t.Fatal(snapshot.authErr)
+	}
+	slow := snapshot.results["slow"]
+	if !errors.Is(slow.err, context.DeadlineExceeded) || len(slow.metrics) != 1 {
+		t.Errorf("expected 1 metric and the deadline exceeded from the slow collector, got %d and %v", len(slow.metrics), slow.err)
+	}
+	fast := snapshot.results["fast"]
+	if fast.err != nil || len(fast.metrics) != 3 {
+		t.Errorf("expected 3 metrics from the fast collector, got %d and %v", len(fast.metrics), fast.err)
+	}
+	if _, ok := target.getLastCollection("slow"); ok {
+		t.Error("expected the slow collector not to be recorded as collected")
+	}
+	if _, ok := target.getLastCollection("fast"); !ok {
+		t.Error("expected the fast collector to be recorded as collected")
+	}
+}
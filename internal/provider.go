@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gophercloud/gophercloud/v2"
+	computeLimits "github.com/gophercloud/gophercloud/v2/openstack/compute/v2/limits"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
+	lbQuotas "github.com/gophercloud/gophercloud/v2/openstack/loadbalancer/v2/quotas"
+)
+
+// Names of the providers, autoProvider detects the provider from the service
+// catalog
+const (
+	autoProvider       = "auto"
+	openStackProvider  = "openstack"
+	otcProvider        = "otc"
+	cloudFerroProvider = "cloudferro"
+)
+
+// Provider implements the parts of the exporter which differ between the
+// OpenStack based clouds
+type Provider interface {
+	// ComputeLimits returns the absolute compute limits of the project
+	ComputeLimits(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target) (*computeLimits.Limits, error)
+	// VolumeQuota returns the volume quota of the project
+	VolumeQuota(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target) (*VolumeQuota, error)
+	// Containers lists the object storage containers of the project. The
+	// containers collected are returned even when some of them failed.
+	Containers(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target) ([]Container, error)
+	// LoadBalancerClient returns a client for the Octavia compatible load
+	// balancer API
+	LoadBalancerClient(providerClient *gophercloud.ProviderClient, target *Target) (*gophercloud.ServiceClient, error)
+	// LoadBalancerQuota returns the load balancer quota of the project
+	LoadBalancerQuota(ctx context.Context, lbClient *gophercloud.ServiceClient, target *Target) (*lbQuotas.Quota, error)
+}
+
+// VolumeQuota is the volume quota of a project. Values the provider cannot
+// tell are nil.
+type VolumeQuota struct {
+	MaxVolumes    *float64
+	MaxGigabytes  *float64
+	VolumesUsed   *float64
+	GigabytesUsed *float64
+}
+
+var providers = map[string]Provider{
+	openStackProvider:  openStack{},
+	otcProvider:        openTelekomCloud{},
+	cloudFerroProvider: cloudFerro{},
+}
+
+var defaultProvider = kingpin.Flag("provider", "The provider of the targets, detected from the service catalog when auto").Default(autoProvider).Enum(providerNames()...)
+
+func providerNames() []string {
+	names := []string{autoProvider}
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+func isProviderName(name string) bool {
+	_, ok := providers[name]
+	return ok || name == autoProvider
+}
+
+// detectProvider tells the provider from the service catalog of the token.
+// OTC has its own services like EVS in the catalog, CloudFerro is recognized by
+// the domain of its endpoints.
+func detectProvider(providerClient *gophercloud.ProviderClient) string {
+	result, ok := providerClient.GetAuthResult().(interface {
+		ExtractServiceCatalog() (*tokens.ServiceCatalog, error)
+	})
+	if !ok {
+		return openStackProvider
+	}
+	catalog, err := result.ExtractServiceCatalog()
+	if err != nil {
+		return openStackProvider
+	}
+
+	for _, entry := range catalog.Entries {
+		if entry.Type == "evs" {
+			return otcProvider
+		}
+		for _, endpoint := range entry.Endpoints {
+			endpointURL, err := url.Parse(endpoint.URL)
+			if err != nil {
+				continue
+			}
+			if strings.HasSuffix(endpointURL.Hostname(), ".cloudferro.com") {
+				return cloudFerroProvider
+			}
+		}
+	}
+	return openStackProvider
+}
+
+// openStack is a vanilla OpenStack cloud
+type openStack struct{}
+
+func (openStack) ComputeLimits(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target) (*computeLimits.Limits, error) {
+	return getComputeLimits(ctx, providerClient, target.CloudConfig)
+}
+
+func (openStack) VolumeQuota(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target) (*VolumeQuota, error) {
+	volumeLimits, err := getVolumeLimits(ctx, providerClient, target.CloudConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	maxVolumes := float64(volumeLimits.Absolute.MaxTotalVolumes)
+	maxGigabytes := float64(volumeLimits.Absolute.MaxTotalVolumeGigabytes)
+	volumesUsed := float64(volumeLimits.Absolute.TotalVolumesUsed)
+	gigabytesUsed := float64(volumeLimits.Absolute.TotalGigabytesUsed)
+	return &VolumeQuota{
+		MaxVolumes:    &maxVolumes,
+		MaxGigabytes:  &maxGigabytes,
+		VolumesUsed:   &volumesUsed,
+		GigabytesUsed: &gigabytesUsed,
+	}, nil
+}
+
+func (openStack) Containers(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target) ([]Container, error) {
+	return getContainerList(ctx, providerClient, target.CloudConfig)
+}
+
+func (openStack) LoadBalancerClient(providerClient *gophercloud.ProviderClient, target *Target) (*gophercloud.ServiceClient, error) {
+	return getLoadBalancerClient(providerClient, target)
+}
+
+func (openStack) LoadBalancerQuota(ctx context.Context, lbClient *gophercloud.ServiceClient, target *Target) (*lbQuotas.Quota, error) {
+	return getLoadBalancerQuota(ctx, lbClient, target.ProjectID())
+}
+
+// cloudFerro runs vanilla OpenStack APIs
+type cloudFerro struct {
+	openStack
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var statusThresholds = kingpin.Flag("status.threshold", "Time after which a resource in the status counts as stuck, as <status>=<duration>, can be repeated. The statuses are the transitional ones tracked.").
+	Default("BUILD=1h", "REBUILD=1h", "RESIZE=1h", "MIGRATING=1h", "creating=1h", "deleting=1h", "attaching=1h", "detaching=1h", "extending=1h").
+	StringMap()
+
+func init() {
+	flagValidators = append(flagValidators, func() error {
+		_, err := parseStatusThresholds()
+		return err
+	})
+}
+
+// parseStatusThresholds returns the durations of --status.threshold per status
+func parseStatusThresholds() (map[string]time.Duration, error) {
+	thresholds := make(map[string]time.Duration, len(*statusThresholds))
+	for status, value := range *statusThresholds {
+		threshold, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --status.threshold for %s: %w", status, err)
+		}
+		thresholds[status] = threshold
+	}
+	return thresholds, nil
+}
+
+// statusTracker remembers since when the resources of a type are in their
+// current status. Only changes between collections are seen, so the time is
+// measured from the first collection a resource was seen in its status, at the
+// latest from the start of the exporter.
+type statusTracker struct {
+	resource      string
+	thresholds    map[string]time.Duration
+	duration      *prometheus.Desc
+	overThreshold *prometheus.Desc
+
+	mu    sync.Mutex
+	since map[*Target]map[string]resourceStatus
+}
+
+type resourceStatus struct {
+	status string
+	since  time.Time
+}
+
+// newStatusTracker creates a tracker for the transitional statuses of
+// --status.threshold of the type of resource. The flag was validated at
+// startup.
+func newStatusTracker(resource string) *statusTracker {
+	thresholds, _ := parseStatusThresholds()
+
+	return &statusTracker{
+		resource:   resource,
+		thresholds: thresholds,
+		duration: prometheus.NewDesc("openstack_resource_status_duration_seconds",
+			"The time the resource is in its transitional status in seconds",
+			targetLabels("resource", "id", "status"), nil,
+		),
+		overThreshold: prometheus.NewDesc("openstack_resource_status_over_threshold_count",
+			"Number of resources in the transitional status for longer than its --status.threshold",
+			targetLabels("resource", "status"), nil,
+		),
+		since: make(map[*Target]map[string]resourceStatus),
+	}
+}
+
+// update records the current statuses of the resources of the target, keyed
+// by their ID, and sends the metrics of the ones in a transitional status.
+// Resources which are gone are forgotten.
+func (t *statusTracker) update(target *Target, statuses map[string]string, now time.Time, ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	previous := t.since[target]
+	current := make(map[string]resourceStatus, len(statuses))
+	for id, status := range statuses {
+		if seen, ok := previous[id]; ok && seen.status == status {
+			current[id] = seen
+		} else {
+			current[id] = resourceStatus{status: status, since: now}
+		}
+	}
+	t.since[target] = current
+	t.mu.Unlock()
+
+	overThreshold := make(map[string]int)
+	for id, seen := range current {
+		threshold, ok := t.thresholds[seen.status]
+		if !ok {
+			continue
+		}
+		duration := now.Sub(seen.since)
+		ch <- prometheus.MustNewConstMetric(t.duration, prometheus.GaugeValue, duration.Seconds(), target.labelValues(t.resource, id, seen.status)...)
+
+		// Statuses seen are reported even without stuck resources, so
+		// alerts can tell 0 from a failed collection
+		count := overThreshold[seen.status]
+		if duration > threshold {
+			count++
+		}
+		overThreshold[seen.status] = count
+	}
+	for status, count := range overThreshold {
+		ch <- prometheus.MustNewConstMetric(t.overThreshold, prometheus.GaugeValue, float64(count), target.labelValues(t.resource, status)...)
+	}
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// collectSamples runs update and returns the values of the metrics of desc,
+// keyed by their labels without the target labels
+func collectSamples(t *testing.T, desc *prometheus.Desc, update func(ch chan<- prometheus.Metric)) map[string]float64 {
+	ch := make(chan prometheus.Metric, 100)
+	update(ch)
+	close(ch)
+
+	samples := make(map[string]float64)
+	for metric := range ch {
+		if metric.Desc() != desc {
+			continue
+		}
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		var labels []string
+		for _, label := range m.GetLabel() {
+			if label.GetName() != "cloud" && label.GetName() != "project_id" && label.GetName() != "region" {
+				labels = append(labels, label.GetName()+"="+label.GetValue())
+			}
+		}
+		sort.Strings(labels)
+		key := strings.Join(labels, ",")
+		switch {
+		case m.Gauge != nil:
+			samples[key] = m.GetGauge().GetValue()
+		case m.Counter != nil:
+			samples[key] = m.GetCounter().GetValue()
+		case m.Histogram != nil:
+			samples[key] = float64(m.GetHistogram().GetSampleCount())
+		}
+	}
+	return samples
+}
+
+func newTestTarget(name string) *Target {
+	return &Target{Name: name, CloudConfig: &CloudConfig{}, projectID: "p1", lastCollections: make(map[string]time.Time)}
+}
+
+func TestParseStatusThresholds(t *testing.T) {
+	thresholds := *statusThresholds
+	defer func() { *statusThresholds = thresholds }()
+
+	*statusThresholds = map[string]string{"BUILD": "1h", "creating": "90s"}
+	parsed, err := parseStatusThresholds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]time.Duration{"BUILD": time.Hour, "creating": 90 * time.Second}
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Errorf("expected %v, got %v", expected, parsed)
+	}
+
+	*statusThresholds = map[string]string{"BUILD": "1h", "creating": "soon"}
+	if _, err := parseStatusThresholds(); err == nil {
+		t.Error("expected an error for an invalid duration")
+	}
+}
+
+func TestStatusTrackerUpdate(t *testing.T) {
+	thresholds := *statusThresholds
+	defer func() { *statusThresholds = thresholds }()
+	*statusThresholds = map[string]string{"BUILD": "1h", "creating": "10m"}
+
+	tracker := newStatusTracker("server")
+	target := newTestTarget("a")
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		name          string
+		after         time.Duration
+		statuses      map[string]string
+		durations     map[string]float64
+		overThreshold map[string]float64
+	}{
+		{
+			name:          "first collection",
+			statuses:      map[string]string{"s1": "BUILD", "s2": "ACTIVE", "s3": "creating"},
+			durations:     map[string]float64{"id=s1,resource=server,status=BUILD": 0, "id=s3,resource=server,status=creating": 0},
+			overThreshold: map[string]float64{"resource=server,status=BUILD": 0, "resource=server,status=creating": 0},
+		},
+		{
+			name:     "status kept and changed",
+			after:    30 * time.Minute,
+			statuses: map[string]string{"s1": "BUILD", "s2": "BUILD", "s3": "creating"},
+			durations: map[string]float64{
+				"id=s1,resource=server,status=BUILD":    1800,
+				"id=s2,resource=server,status=BUILD":    0,
+				"id=s3,resource=server,status=creating": 1800,
+			},
+			overThreshold: map[string]float64{"resource=server,status=BUILD": 0, "resource=server,status=creating": 1},
+		},
+		{
+			name:          "resources gone or settled",
+			after:         90 * time.Minute,
+			statuses:      map[string]string{"s1": "BUILD", "s3": "ACTIVE"},
+			durations:     map[string]float64{"id=s1,resource=server,status=BUILD": 5400},
+			overThreshold: map[string]float64{"resource=server,status=BUILD": 1},
+		},
+		{
+			name:          "resource back after it was gone",
+			after:         100 * time.Minute,
+			statuses:      map[string]string{"s1": "ACTIVE", "s2": "BUILD"},
+			durations:     map[string]float64{"id=s2,resource=server,status=BUILD": 0},
+			overThreshold: map[string]float64{"resource=server,status=BUILD": 0},
+		},
+		{
+			name:          "nothing in transition",
+			after:         110 * time.Minute,
+			statuses:      map[string]string{"s1": "ACTIVE", "s2": "ACTIVE"},
+			durations:     map[string]float64{},
+			overThreshold: map[string]float64{},
+		},
+	}
+
+	for _, step := range steps {
+		now := start.Add(step.after)
+		durations := collectSamples(t, tracker.duration, func(ch chan<- prometheus.Metric) {
+			tracker.update(target, step.statuses, now, ch)
+		})
+		if !reflect.DeepEqual(durations, step.durations) {
+			t.Errorf("%s: expected durations %v, got %v", step.name, step.durations, durations)
+		}
+		// The previous update is repeated to read the other metric
+		overThreshold := collectSamples(t, tracker.overThreshold, func(ch chan<- prometheus.Metric) {
+			tracker.update(target, step.statuses, now, ch)
+		})
+		if !reflect.DeepEqual(overThreshold, step.overThreshold) {
+			t.Errorf("%s: expected over threshold %v, got %v", step.name, step.overThreshold, overThreshold)
+		}
+	}
+
+	// Targets are tracked independently
+	other := newTestTarget("b")
+	durations := collectSamples(t, tracker.duration, func(ch chan<- prometheus.Metric) {
+		tracker.update(other, map[string]string{"s2": "BUILD"}, start.Add(120*time.Minute), ch)
+	})
+	if expected := map[string]float64{"id=s2,resource=server,status=BUILD": 0}; !reflect.DeepEqual(durations, expected) {
+		t.Errorf("expected durations %v for another target, got %v", expected, durations)
+	}
+}
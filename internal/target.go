@@ -0,0 +1,210 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
+)
+
+// tokenRenewalMargin is how long before its expiry a token gets renewed
+const tokenRenewalMargin = time.Minute
+
+// Target is a single cloud/project/region the exporter collects metrics from
+type Target struct {
+	Name        string
+	CloudConfig *CloudConfig
+	StaticQuota StaticQuota
+
+	// authMu serializes authentication, so concurrent scrapes share one token
+	authMu         sync.Mutex
+	providerClient *gophercloud.ProviderClient
+
+	mu        sync.Mutex
+	projectID string
+	// providerName is detected from the service catalog when not configured
+	providerName string
+	authStats    authStats
+	// lastCollections is the time each collector last succeeded
+	lastCollections map[string]time.Time
+}
+
+// authStats are the authentication statistics of a target
+type authStats struct {
+	successes        int
+	failures         int
+	tokenExpiry      time.Time
+	credentialExpiry time.Time
+}
+
+// NewTarget loads the cloud configuration of the target and applies the
+// project and region overrides
+func NewTarget(targetConfig TargetConfig) (*Target, error) {
+	cloudConfig, err := LoadCloudConfig(targetConfig.Cloud)
+	if err != nil {
+		return nil, err
+	}
+
+	if targetConfig.ProjectID != "" {
+		cloudConfig.AuthOptions.TenantID = targetConfig.ProjectID
+		cloudConfig.AuthOptions.TenantName = ""
+	}
+	if targetConfig.Region != "" {
+		cloudConfig.EndpointOpts.Region = targetConfig.Region
+	}
+
+	providerName := coalesce(targetConfig.Provider, *defaultProvider)
+	if providerName == autoProvider {
+		providerName = ""
+	}
+
+	return &Target{
+		Name:            targetConfig.name(),
+		CloudConfig:     cloudConfig,
+		StaticQuota:     targetConfig.StaticQuota,
+		projectID:       cloudConfig.AuthOptions.TenantID,
+		providerName:    providerName,
+		lastCollections: make(map[string]time.Time),
+	}, nil
+}
+
+// ProviderClient returns the provider client of the target. A new token is
+// only requested on first use and shortly before the current one expires,
+// rejected tokens are renewed by gophercloud.
+func (t *Target) ProviderClient(ctx context.Context) (*gophercloud.ProviderClient, error) {
+	t.authMu.Lock()
+	defer t.authMu.Unlock()
+
+	if t.providerClient != nil {
+		t.mu.Lock()
+		tokenExpiry := t.authStats.tokenExpiry
+		t.mu.Unlock()
+
+		if tokenExpiry.IsZero() || time.Until(tokenExpiry) > tokenRenewalMargin {
+			return t.providerClient, nil
+		}
+		if t.providerClient.ReauthFunc != nil {
+			level.Debug(logger).Log("message", "Renewing token", "target", t.Name, "expiry", tokenExpiry)
+			if err := t.providerClient.Reauthenticate(ctx, ""); err != nil {
+				return nil, err
+			}
+			return t.providerClient, nil
+		}
+	}
+
+	providerClient, err := authenticateOpenStack(ctx, t.CloudConfig)
+	t.recordAuthentication(providerClient, err)
+	if err != nil {
+		return nil, err
+	}
+
+	expiry, err := credentialExpiry(ctx, providerClient, t.CloudConfig)
+	if err != nil {
+		level.Warn(logger).Log("message", "Failed to get the credential expiry", "target", t.Name, "err", err)
+	}
+	t.mu.Lock()
+	t.authStats.credentialExpiry = expiry
+	if t.providerName == "" {
+		t.providerName = detectProvider(providerClient)
+		level.Info(logger).Log("message", "Detected provider", "target", t.Name, "provider", t.providerName)
+	}
+	t.mu.Unlock()
+
+	if reauth := providerClient.ReauthFunc; reauth != nil {
+		providerClient.ReauthFunc = func(ctx context.Context) error {
+			err := reauth(ctx)
+			t.recordAuthentication(providerClient, err)
+			return err
+		}
+	}
+	t.providerClient = providerClient
+	return providerClient, nil
+}
+
+// recordAuthentication updates the authentication statistics and takes the
+// token expiry and project ID from the result of an authentication
+func (t *Target) recordAuthentication(providerClient *gophercloud.ProviderClient, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err != nil {
+		t.authStats.failures++
+		return
+	}
+	t.authStats.successes++
+
+	result, ok := providerClient.GetAuthResult().(interface {
+		ExtractToken() (*tokens.Token, error)
+		ExtractProject() (*tokens.Project, error)
+	})
+	if !ok {
+		return
+	}
+	if token, err := result.ExtractToken(); err == nil {
+		t.authStats.tokenExpiry = token.ExpiresAt
+	}
+	// The project ID is not known when the target is configured with a
+	// project name only
+	if project, err := result.ExtractProject(); err == nil && project != nil {
+		t.projectID = project.ID
+	}
+}
+
+func (t *Target) getAuthStats() authStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.authStats
+}
+
+// Provider returns the provider of the target, vanilla OpenStack until it was
+// detected
+func (t *Target) Provider() Provider {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if provider, ok := providers[t.providerName]; ok {
+		return provider
+	}
+	return providers[openStackProvider]
+}
+
+func (t *Target) recordCollection(collector string, timestamp time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastCollections[collector] = timestamp
+}
+
+func (t *Target) getLastCollection(collector string) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timestamp, ok := t.lastCollections[collector]
+	return timestamp, ok
+}
+
+// targetLabels returns the label names identifying a target followed by the
+// given label names
+func targetLabels(labels ...string) []string {
+	return append([]string{"cloud", "project_id", "region"}, labels...)
+}
+
+// ProjectID returns the ID of the project, which is only known after the
+// first authentication when the target is configured with a project name
+func (t *Target) ProjectID() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.projectID
+}
+
+// labelValues returns the values for targetLabels followed by the given values.
+// The cloud label is the name of the target, which is unique, as targets of
+// the same cloud entry would otherwise produce the same series.
+func (t *Target) labelValues(values ...string) []string {
+	return append([]string{t.Name, t.ProjectID(), t.CloudConfig.EndpointOpts.Region}, values...)
+}
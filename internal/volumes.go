@@ -3,15 +3,231 @@ package internal
 import (
 	"context"
 	"encoding/json"
-	"os"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
 
 	"github.com/go-kit/log/level"
 	"github.com/gophercloud/gophercloud/v2"
 	"github.com/gophercloud/gophercloud/v2/openstack"
 	volumeLimits "github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v2/limits"
 	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v2/volumes"
+	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/backups"
+	volumeQuotasets "github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/quotasets"
+	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/snapshots"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+var perVolumeInfo = kingpin.Flag("volume.per-volume-info", "Export the size and attachment of every volume, which adds a series per volume").Default("false").Bool()
+
+func init() {
+	registerCollector("volume", "volume", true, newVolumeCollector)
+	registerCollector("volumebackup", "volume", true, newVolumeBackupCollector)
+}
+
+type volumeCollector struct {
+	maxTotalVolumeGigabytes *prometheus.Desc
+	maxTotalVolumes         *prometheus.Desc
+	perStatusVolumeCount    *prometheus.Desc
+	totalGigabytesUsed      *prometheus.Desc
+	totalVolumesUsed        *prometheus.Desc
+	volumeCount             *prometheus.Desc
+	volumeGigabytes         *prometheus.Desc
+	volumeInfo              *prometheus.Desc
+	volumeSize              *prometheus.Desc
+	quotaLimit              *prometheus.Desc
+	quotaUsed               *prometheus.Desc
+	quotaReserved           *prometheus.Desc
+	typeQuotaLimit          *prometheus.Desc
+	typeQuotaUsed           *prometheus.Desc
+	typeQuotaReserved       *prometheus.Desc
+	statuses                *statusTracker
+}
+
+func newVolumeCollector() Collector {
+	return &volumeCollector{
+		maxTotalVolumeGigabytes: prometheus.NewDesc("openstack_max_total_volume_gigabytes",
+			"The limit of total volume size in the project",
+			targetLabels(), nil,
+		),
+		maxTotalVolumes: prometheus.NewDesc("openstack_max_total_volumes",
+			"The limit of total volumes in the project",
+			targetLabels(), nil,
+		),
+		perStatusVolumeCount: prometheus.NewDesc("openstack_per_status_volume_count",
+			"Number of volumes per status",
+			targetLabels("status"), nil,
+		),
+		totalGigabytesUsed: prometheus.NewDesc("openstack_total_volume_gigabytes_used",
+			"The current total of gigabytes used in volumes",
+			targetLabels(), nil,
+		),
+		totalVolumesUsed: prometheus.NewDesc("openstack_total_volumes_used",
+			"The current number of volumes",
+			targetLabels(), nil,
+		),
+		volumeCount: prometheus.NewDesc("openstack_volume_count",
+			"Number of volumes per volume type, availability zone, bootable and attached state",
+			targetLabels("volume_type", "availability_zone", "bootable", "attached"), nil,
+		),
+		volumeGigabytes: prometheus.NewDesc("openstack_volume_gigabytes",
+			"The total size of the volumes in gigabytes per volume type, availability zone, bootable and attached state",
+			targetLabels("volume_type", "availability_zone", "bootable", "attached"), nil,
+		),
+		volumeInfo: prometheus.NewDesc("openstack_volume_info",
+			"Information about the volume, server_id lists the servers it is attached to",
+			targetLabels("id", "name", "volume_type", "availability_zone", "bootable", "server_id"), nil,
+		),
+		volumeSize: prometheus.NewDesc("openstack_volume_size_gigabytes",
+			"The size of the volume in gigabytes",
+			targetLabels("id"), nil,
+		),
+		quotaLimit: prometheus.NewDesc("openstack_volume_quota_limit",
+			"The quota limit of the volume resource in the project, -1 when unlimited",
+			targetLabels("resource"), nil,
+		),
+		quotaUsed: prometheus.NewDesc("openstack_volume_quota_used",
+			"The current usage of the volume resource in the project",
+			targetLabels("resource"), nil,
+		),
+		quotaReserved: prometheus.NewDesc("openstack_volume_quota_reserved",
+			"The reserved usage of the volume resource in the project",
+			targetLabels("resource"), nil,
+		),
+		typeQuotaLimit: prometheus.NewDesc("openstack_volume_type_quota_limit",
+			"The quota limit of the volume resource of the volume type in the project, -1 when unlimited",
+			targetLabels("volume_type", "resource"), nil,
+		),
+		typeQuotaUsed: prometheus.NewDesc("openstack_volume_type_quota_used",
+			"The current usage of the volume resource of the volume type in the project",
+			targetLabels("volume_type", "resource"), nil,
+		),
+		typeQuotaReserved: prometheus.NewDesc("openstack_volume_type_quota_reserved",
+			"The reserved usage of the volume resource of the volume type in the project",
+			targetLabels("volume_type", "resource"), nil,
+		),
+		statuses: newStatusTracker("volume"),
+	}
+}
+
+func (collector *volumeCollector) Update(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, ch chan<- prometheus.Metric) error {
+	labels := target.labelValues()
+
+	var errs []error
+
+	volumeList, err := getAllVolumes(ctx, providerClient, target.CloudConfig)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get volumes: %w", err))
+	} else {
+		statusCountVolumes := countVolumePerStatus(volumeList)
+		for status, count := range statusCountVolumes {
+			statusCountMetric := prometheus.MustNewConstMetric(collector.perStatusVolumeCount, prometheus.GaugeValue, float64(count), target.labelValues(status)...)
+			ch <- statusCountMetric
+		}
+
+		volumeStatuses := make(map[string]string, len(volumeList))
+		for _, volume := range volumeList {
+			volumeStatuses[volume.ID] = volume.Status
+		}
+		collector.statuses.update(target, volumeStatuses, time.Now(), ch)
+
+		volumeCount := make(map[volumeKey]int)
+		volumeGigabytes := make(map[volumeKey]int)
+		for _, volume := range volumeList {
+			key := volumeKey{
+				volumeType:       volume.VolumeType,
+				availabilityZone: volume.AvailabilityZone,
+				bootable:         volume.Bootable,
+				attached:         strconv.FormatBool(len(volume.Attachments) > 0),
+			}
+			volumeCount[key]++
+			volumeGigabytes[key] += volume.Size
+
+			if *perVolumeInfo {
+				serverIDs := make([]string, 0, len(volume.Attachments))
+				for _, attachment := range volume.Attachments {
+					serverIDs = append(serverIDs, attachment.ServerID)
+				}
+				ch <- prometheus.MustNewConstMetric(collector.volumeInfo, prometheus.GaugeValue, 1, target.labelValues(volume.ID, volume.Name, volume.VolumeType, volume.AvailabilityZone, volume.Bootable, strings.Join(serverIDs, ","))...)
+				ch <- prometheus.MustNewConstMetric(collector.volumeSize, prometheus.GaugeValue, float64(volume.Size), target.labelValues(volume.ID)...)
+			}
+		}
+		for key, count := range volumeCount {
+			keyLabels := target.labelValues(key.volumeType, key.availabilityZone, key.bootable, key.attached)
+			ch <- prometheus.MustNewConstMetric(collector.volumeCount, prometheus.GaugeValue, float64(count), keyLabels...)
+			ch <- prometheus.MustNewConstMetric(collector.volumeGigabytes, prometheus.GaugeValue, float64(volumeGigabytes[key]), keyLabels...)
+		}
+	}
+
+	volumeQuota, err := target.Provider().VolumeQuota(ctx, providerClient, target)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get volume quota: %w", err))
+	} else {
+		// Not every provider can tell all values, the unknown ones are left
+		// out rather than reported as zero
+		if volumeQuota.MaxGigabytes != nil {
+			ch <- prometheus.MustNewConstMetric(collector.maxTotalVolumeGigabytes, prometheus.GaugeValue, *volumeQuota.MaxGigabytes, labels...)
+		}
+		if volumeQuota.MaxVolumes != nil {
+			ch <- prometheus.MustNewConstMetric(collector.maxTotalVolumes, prometheus.GaugeValue, *volumeQuota.MaxVolumes, labels...)
+		}
+		if volumeQuota.GigabytesUsed != nil {
+			ch <- prometheus.MustNewConstMetric(collector.totalGigabytesUsed, prometheus.GaugeValue, *volumeQuota.GigabytesUsed, labels...)
+		}
+		if volumeQuota.VolumesUsed != nil {
+			ch <- prometheus.MustNewConstMetric(collector.totalVolumesUsed, prometheus.GaugeValue, *volumeQuota.VolumesUsed, labels...)
+		}
+	}
+
+	quotaSet, err := getVolumeQuotaSet(ctx, providerClient, target)
+	if err == nil {
+		var quotas map[string]volumeQuotasets.QuotaUsage
+		var typeQuotas map[volumeTypeQuotaKey]volumeQuotasets.QuotaUsage
+		quotas, typeQuotas, err = parseVolumeQuotaSet(quotaSet)
+		for resource, quota := range quotas {
+			ch <- prometheus.MustNewConstMetric(collector.quotaLimit, prometheus.GaugeValue, float64(quota.Limit), target.labelValues(resource)...)
+			ch <- prometheus.MustNewConstMetric(collector.quotaUsed, prometheus.GaugeValue, float64(quota.InUse), target.labelValues(resource)...)
+			ch <- prometheus.MustNewConstMetric(collector.quotaReserved, prometheus.GaugeValue, float64(quota.Reserved), target.labelValues(resource)...)
+		}
+		for key, quota := range typeQuotas {
+			keyLabels := target.labelValues(key.volumeType, key.resource)
+			ch <- prometheus.MustNewConstMetric(collector.typeQuotaLimit, prometheus.GaugeValue, float64(quota.Limit), keyLabels...)
+			ch <- prometheus.MustNewConstMetric(collector.typeQuotaUsed, prometheus.GaugeValue, float64(quota.InUse), keyLabels...)
+			ch <- prometheus.MustNewConstMetric(collector.typeQuotaReserved, prometheus.GaugeValue, float64(quota.Reserved), keyLabels...)
+		}
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get volume quota set: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+type volumeKey struct {
+	volumeType       string
+	availabilityZone string
+	bootable         string
+	attached         string
+}
+
+type volumeTypeQuotaKey struct {
+	volumeType string
+	resource   string
+}
+
+// volumeQuotaResources are the resources of the quota set which are not
+// covered by the limits of the provider
+var volumeQuotaResources = []string{"backup_gigabytes", "backups", "snapshots"}
+
+// volumeTypeQuotaResources are the resources Cinder has a quota per volume
+// type for, reported as <resource>_<volume type> in the quota set
+var volumeTypeQuotaResources = []string{"gigabytes", "snapshots", "volumes"}
+
 type Volume struct {
 	ID     string `json:"id"`
 	Status string `json:"status"`
@@ -24,17 +240,13 @@ type AbsoluteVolumeLimits struct {
 	TotalGigabytesUsed      int `json:"totalGigabytesUsed"`
 }
 
-func getVolumeLimits(providerClient *gophercloud.ProviderClient) (*volumeLimits.Limits, error) {
+func getVolumeLimits(ctx context.Context, providerClient *gophercloud.ProviderClient, cloudConfig *CloudConfig) (*volumeLimits.Limits, error) {
 	var blockStorageClient *gophercloud.ServiceClient
 	var err error
-	if os.Getenv("OS_BLOCKSTORAGE_V") == "2" {
-		blockStorageClient, err = openstack.NewBlockStorageV2(providerClient, gophercloud.EndpointOpts{
-			Region: os.Getenv("OS_REGION_NAME"),
-		})
+	if cloudConfig.BlockStorageVersion == "2" {
+		blockStorageClient, err = openstack.NewBlockStorageV2(providerClient, cloudConfig.EndpointOpts)
 	} else {
-		blockStorageClient, err = openstack.NewBlockStorageV3(providerClient, gophercloud.EndpointOpts{
-			Region: os.Getenv("OS_REGION_NAME"),
-		})
+		blockStorageClient, err = openstack.NewBlockStorageV3(providerClient, cloudConfig.EndpointOpts)
 	}
 	if err != nil {
 		return nil, err
@@ -42,7 +254,7 @@ func getVolumeLimits(providerClient *gophercloud.ProviderClient) (*volumeLimits.
 
 	level.Debug(logger).Log("message", "Getting volume limits")
 
-	volumeLimits, err := volumeLimits.Get(context.Background(), blockStorageClient).Extract()
+	volumeLimits, err := volumeLimits.Get(ctx, blockStorageClient).Extract()
 	if err != nil {
 		return nil, err
 	}
@@ -50,12 +262,10 @@ func getVolumeLimits(providerClient *gophercloud.ProviderClient) (*volumeLimits.
 	return volumeLimits, nil
 }
 
-func getAllVolumes(providerClient *gophercloud.ProviderClient) []volumes.Volume {
-	blockStorageClient, err := openstack.NewBlockStorageV3(providerClient, gophercloud.EndpointOpts{
-		Region: os.Getenv("OS_REGION_NAME"),
-	})
+func getAllVolumes(ctx context.Context, providerClient *gophercloud.ProviderClient, cloudConfig *CloudConfig) ([]volumes.Volume, error) {
+	blockStorageClient, err := openstack.NewBlockStorageV3(providerClient, cloudConfig.EndpointOpts)
 	if err != nil {
-		level.Error(logger).Log("message", "Failed to retrieve volumes", "err", err)
+		return nil, err
 	}
 
 	listOpts := volumes.ListOpts{
@@ -64,17 +274,17 @@ func getAllVolumes(providerClient *gophercloud.ProviderClient) []volumes.Volume
 
 	level.Debug(logger).Log("message", "Getting all volumes")
 
-	allPages, err := volumes.List(blockStorageClient, listOpts).AllPages(context.TODO())
+	allPages, err := volumes.List(blockStorageClient, listOpts).AllPages(ctx)
 	if err != nil {
-		level.Error(logger).Log("message", "Failed to retrieve all volumes", "err", err)
+		return nil, err
 	}
 
 	allVolumes, err := volumes.ExtractVolumes(allPages)
 	if err != nil {
-		level.Error(logger).Log("message", "Failed to retrieve all volumes", "err", err)
+		return nil, err
 	}
 
-	return allVolumes
+	return allVolumes, nil
 }
 
 func countVolumePerStatus(volumeList []volumes.Volume) map[string]int {
@@ -96,3 +306,195 @@ func countVolumePerStatus(volumeList []volumes.Volume) map[string]int {
 
 	return statusCount
 }
+
+type volumeBackupCollector struct {
+	perStatusSnapshotCount     *prometheus.Desc
+	perStatusSnapshotGigabytes *prometheus.Desc
+	perStatusBackupCount       *prometheus.Desc
+	perStatusBackupGigabytes   *prometheus.Desc
+	lastBackup                 *prometheus.Desc
+}
+
+func newVolumeBackupCollector() Collector {
+	return &volumeBackupCollector{
+		perStatusSnapshotCount: prometheus.NewDesc("openstack_per_status_volume_snapshot_count",
+			"Number of volume snapshots per status",
+			targetLabels("status"), nil,
+		),
+		perStatusSnapshotGigabytes: prometheus.NewDesc("openstack_per_status_volume_snapshot_gigabytes",
+			"The total size of the volume snapshots in gigabytes per status",
+			targetLabels("status"), nil,
+		),
+		perStatusBackupCount: prometheus.NewDesc("openstack_per_status_volume_backup_count",
+			"Number of volume backups per status",
+			targetLabels("status"), nil,
+		),
+		perStatusBackupGigabytes: prometheus.NewDesc("openstack_per_status_volume_backup_gigabytes",
+			"The total size of the volume backups in gigabytes per status",
+			targetLabels("status"), nil,
+		),
+		lastBackup: prometheus.NewDesc("openstack_volume_last_backup_timestamp_seconds",
+			"The time the newest available backup of the volume was created in seconds since epoch, 0 without any",
+			targetLabels("volume_id"), nil,
+		),
+	}
+}
+
+func (collector *volumeBackupCollector) Update(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target, ch chan<- prometheus.Metric) error {
+	var errs []error
+
+	snapshotList, err := getAllSnapshots(ctx, providerClient, target.CloudConfig)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get volume snapshots: %w", err))
+	} else {
+		statusCount := make(map[string]int)
+		statusGigabytes := make(map[string]int)
+		for _, snapshot := range snapshotList {
+			statusCount[snapshot.Status]++
+			statusGigabytes[snapshot.Status] += snapshot.Size
+		}
+		for status, count := range statusCount {
+			ch <- prometheus.MustNewConstMetric(collector.perStatusSnapshotCount, prometheus.GaugeValue, float64(count), target.labelValues(status)...)
+			ch <- prometheus.MustNewConstMetric(collector.perStatusSnapshotGigabytes, prometheus.GaugeValue, float64(statusGigabytes[status]), target.labelValues(status)...)
+		}
+	}
+
+	// The volumes without a backup are only known from the volume list
+	volumeList, volumesErr := getAllVolumes(ctx, providerClient, target.CloudConfig)
+	if volumesErr != nil {
+		errs = append(errs, fmt.Errorf("failed to get volumes: %w", volumesErr))
+	}
+
+	backupList, err := getAllBackups(ctx, providerClient, target.CloudConfig)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get volume backups: %w", err))
+	} else {
+		statusCount := make(map[string]int)
+		statusGigabytes := make(map[string]int)
+		for _, backup := range backupList {
+			statusCount[backup.Status]++
+			statusGigabytes[backup.Status] += backup.Size
+		}
+		for status, count := range statusCount {
+			ch <- prometheus.MustNewConstMetric(collector.perStatusBackupCount, prometheus.GaugeValue, float64(count), target.labelValues(status)...)
+			ch <- prometheus.MustNewConstMetric(collector.perStatusBackupGigabytes, prometheus.GaugeValue, float64(statusGigabytes[status]), target.labelValues(status)...)
+		}
+		// Backups are kept after their volume was deleted, so only the
+		// listed volumes are exported
+		if volumesErr == nil {
+			lastBackup := lastBackupPerVolume(backupList)
+			for _, volume := range volumeList {
+				var timestamp float64
+				if createdAt, ok := lastBackup[volume.ID]; ok {
+					timestamp = float64(createdAt.Unix())
+				}
+				ch <- prometheus.MustNewConstMetric(collector.lastBackup, prometheus.GaugeValue, timestamp, target.labelValues(volume.ID)...)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func getAllSnapshots(ctx context.Context, providerClient *gophercloud.ProviderClient, cloudConfig *CloudConfig) ([]snapshots.Snapshot, error) {
+	blockStorageClient, err := openstack.NewBlockStorageV3(providerClient, cloudConfig.EndpointOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	level.Debug(logger).Log("message", "Getting all volume snapshots")
+
+	allPages, err := snapshots.List(blockStorageClient, snapshots.ListOpts{}).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshots.ExtractSnapshots(allPages)
+}
+
+func getAllBackups(ctx context.Context, providerClient *gophercloud.ProviderClient, cloudConfig *CloudConfig) ([]backups.Backup, error) {
+	blockStorageClient, err := openstack.NewBlockStorageV3(providerClient, cloudConfig.EndpointOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	level.Debug(logger).Log("message", "Getting all volume backups")
+
+	allPages, err := backups.ListDetail(blockStorageClient, backups.ListDetailOpts{}).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return backups.ExtractBackups(allPages)
+}
+
+// getVolumeQuotaSet returns the quota set of the project with usage from
+// Cinder, keyed by the resource names. The per type quotas are not part of the
+// QuotaUsageSet of gophercloud.
+func getVolumeQuotaSet(ctx context.Context, providerClient *gophercloud.ProviderClient, target *Target) (map[string]json.RawMessage, error) {
+	blockStorageClient, err := openstack.NewBlockStorageV3(providerClient, target.CloudConfig.EndpointOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	level.Debug(logger).Log("message", "Getting volume quota set")
+
+	var result struct {
+		QuotaSet map[string]json.RawMessage `json:"quota_set"`
+	}
+	if err := volumeQuotasets.GetUsage(ctx, blockStorageClient, target.ProjectID()).ExtractInto(&result); err != nil {
+		return nil, err
+	}
+	return result.QuotaSet, nil
+}
+
+// parseVolumeQuotaSet returns the quotas of volumeQuotaResources and the per
+// volume type quotas of a quota set
+func parseVolumeQuotaSet(quotaSet map[string]json.RawMessage) (map[string]volumeQuotasets.QuotaUsage, map[volumeTypeQuotaKey]volumeQuotasets.QuotaUsage, error) {
+	quotas := make(map[string]volumeQuotasets.QuotaUsage)
+	typeQuotas := make(map[volumeTypeQuotaKey]volumeQuotasets.QuotaUsage)
+	for name, value := range quotaSet {
+		typeKey, isTypeQuota := volumeTypeQuotaKeyOf(name)
+		if !isTypeQuota && !slices.Contains(volumeQuotaResources, name) {
+			continue
+		}
+		var quota volumeQuotasets.QuotaUsage
+		if err := json.Unmarshal(value, &quota); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse quota %s: %w", name, err)
+		}
+		if isTypeQuota {
+			typeQuotas[typeKey] = quota
+		} else {
+			quotas[name] = quota
+		}
+	}
+	return quotas, typeQuotas, nil
+}
+
+// volumeTypeQuotaKeyOf splits the name of a per volume type quota like
+// gigabytes_ssd into the resource and the volume type
+func volumeTypeQuotaKeyOf(name string) (volumeTypeQuotaKey, bool) {
+	for _, resource := range volumeTypeQuotaResources {
+		if volumeType, ok := strings.CutPrefix(name, resource+"_"); ok && volumeType != "" {
+			return volumeTypeQuotaKey{volumeType: volumeType, resource: resource}, true
+		}
+	}
+	return volumeTypeQuotaKey{}, false
+}
+
+// lastBackupPerVolume returns the time the newest available backup of each
+// volume was created. Failed backups do not count, as they cannot be restored.
+func lastBackupPerVolume(backupList []backups.Backup) map[string]time.Time {
+	lastBackup := make(map[string]time.Time)
+
+	for _, backup := range backupList {
+		if backup.Status != "available" {
+			continue
+		}
+		if backup.CreatedAt.After(lastBackup[backup.VolumeID]) {
+			lastBackup[backup.VolumeID] = backup.CreatedAt
+		}
+	}
+
+	return lastBackup
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	volumeQuotasets "github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/quotasets"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseVolumeQuotaSet(t *testing.T) {
+	var quotaSet map[string]json.RawMessage
+	err := json.Unmarshal([]byte(`{
+		"id": "p1",
+		"volumes": {"limit": 10, "in_use": 2, "reserved": 0},
+		"gigabytes": {"limit": 1000, "in_use": 150, "reserved": 0},
+		"snapshots": {"limit": 10, "in_use": 3, "reserved": 1},
+		"backups": {"limit": 10, "in_use": 4, "reserved": 0},
+		"backup_gigabytes": {"limit": 1000, "in_use": 80, "reserved": 0},
+		"per_volume_gigabytes": {"limit": -1, "in_use": 0, "reserved": 0},
+		"groups": {"limit": 10, "in_use": 0, "reserved": 0},
+		"volumes_ssd": {"limit": 5, "in_use": 1, "reserved": 0},
+		"gigabytes_ssd": {"limit": 500, "in_use": 100, "reserved": 0},
+		"snapshots_ssd": {"limit": -1, "in_use": 0, "reserved": 0},
+		"gigabytes_high_iops": {"limit": 200, "in_use": 50, "reserved": 10},
+		"volumes___DEFAULT__": {"limit": -1, "in_use": 1, "reserved": 0}
+	}`), &quotaSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quotas, typeQuotas, err := parseVolumeQuotaSet(quotaSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedQuotas := map[string]volumeQuotasets.QuotaUsage{
+		"backup_gigabytes": {Limit: 1000, InUse: 80},
+		"backups":          {Limit: 10, InUse: 4},
+		"snapshots":        {Limit: 10, InUse: 3, Reserved: 1},
+	}
+	if !reflect.DeepEqual(quotas, expectedQuotas) {
+		t.Errorf("expected quotas %v, got %v", expectedQuotas, quotas)
+	}
+
+	expectedTypeQuotas := map[volumeTypeQuotaKey]volumeQuotasets.QuotaUsage{
+		{volumeType: "ssd", resource: "volumes"}:         {Limit: 5, InUse: 1},
+		{volumeType: "ssd", resource: "gigabytes"}:       {Limit: 500, InUse: 100},
+		{volumeType: "ssd", resource: "snapshots"}:       {Limit: -1},
+		{volumeType: "high_iops", resource: "gigabytes"}: {Limit: 200, InUse: 50, Reserved: 10},
+		{volumeType: "__DEFAULT__", resource: "volumes"}: {Limit: -1, InUse: 1},
+	}
+	if !reflect.DeepEqual(typeQuotas, expectedTypeQuotas) {
+		t.Errorf("expected type quotas %v, got %v", expectedTypeQuotas, typeQuotas)
+	}
+}
+
+func TestParseVolumeQuotaSetInvalid(t *testing.T) {
+	quotaSet := map[string]json.RawMessage{
+		"id":            json.RawMessage(`"p1"`),
+		"gigabytes_ssd": json.RawMessage(`"unlimited"`),
+	}
+	if _, _, err := parseVolumeQuotaSet(quotaSet); err == nil {
+		t.Error("expected an error for an invalid quota")
+	}
+}
+
+func TestVolumeTypeQuotaKeyOf(t *testing.T) {
+	tests := []struct {
+		name string
+		key  volumeTypeQuotaKey
+		ok   bool
+	}{
+		{name: "gigabytes_ssd", key: volumeTypeQuotaKey{volumeType: "ssd", resource: "gigabytes"}, ok: true},
+		{name: "snapshots_high_iops", key: volumeTypeQuotaKey{volumeType: "high_iops", resource: "snapshots"}, ok: true},
+		{name: "volumes_gigabytes_x", key: volumeTypeQuotaKey{volumeType: "gigabytes_x", resource: "volumes"}, ok: true},
+		{name: "gigabytes"},
+		{name: "gigabytes_"},
+		{name: "backup_gigabytes"},
+		{name: "per_volume_gigabytes"},
+		{name: "groups"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, ok := volumeTypeQuotaKeyOf(test.name)
+			if key != test.key || ok != test.ok {
+				t.Errorf("expected %v %t, got %v %t", test.key, test.ok, key, ok)
+			}
+		})
+	}
+}
+
+func TestVolumeBackupCollectorLastBackup(t *testing.T) {
+	volumesFail := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/volumes/detail", func(w http.ResponseWriter, r *http.Request) {
+		if volumesFail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"volumes": [{"id": "v1"}, {"id": "v2"}]}`)
+	})
+	mux.HandleFunc("/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"snapshots": []}`)
+	})
+	mux.HandleFunc("/backups/detail", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"backups": [
+			{"id": "b1", "volume_id": "v1", "status": "available", "created_at": "2026-01-01T00:00:00.000000"},
+			{"id": "b2", "volume_id": "v1", "status": "error", "created_at": "2026-01-02T00:00:00.000000"},
+			{"id": "b3", "volume_id": "deleted", "status": "available", "created_at": "2026-01-01T00:00:00.000000"}
+		]}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	providerClient := &gophercloud.ProviderClient{
+		HTTPClient: *server.Client(),
+		EndpointLocator: func(gophercloud.EndpointOpts) (string, error) {
+			return server.URL + "/", nil
+		},
+	}
+	collector := newVolumeBackupCollector().(*volumeBackupCollector)
+	target := newTestTarget("a")
+
+	samples := collectSamples(t, collector.lastBackup, func(ch chan<- prometheus.Metric) {
+		if err := collector.Update(context.Background(), providerClient, target, ch); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// The backup of the deleted volume is left out
+	expected := map[string]float64{
+		"volume_id=v1": float64(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Unix()),
+		"volume_id=v2": 0,
+	}
+	if !reflect.DeepEqual(samples, expected) {
+		t.Errorf("expected %v, got %v", expected, samples)
+	}
+
+	volumesFail = true
+	samples = collectSamples(t, collector.lastBackup, func(ch chan<- prometheus.Metric) {
+		if err := collector.Update(context.Background(), providerClient, target, ch); err == nil {
+			t.Error("expected an error without the volume list")
+		}
+	})
+	if len(samples) != 0 {
+		t.Errorf("expected no last backup without the volume list, got %v", samples)
+	}
+}
@@ -8,17 +8,19 @@ import (
 	"github.com/alecthomas/kingpin/v2"
 	lib "github.com/eu-cdse/openstack_exporter/internal"
 	"github.com/go-kit/log/level"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/promlog"
 	"github.com/prometheus/common/promlog/flag"
 	"github.com/prometheus/common/version"
 )
 
 var (
-	config      = promlog.Config{}
-	port        = kingpin.Flag("port", "Port to serve the metrics on").Default("9595").Int()
-	volumeLimit = kingpin.Flag("volume.limit", "Max number of volumes when on OTC").Default("-1").Float64()
+	config             = promlog.Config{}
+	port               = kingpin.Flag("port", "Port to serve the metrics on").Default("9595").Int()
+	osCloud            = kingpin.Flag("os.cloud", "Name of the cloud in clouds.yaml to use, the OS_* environment variables are used when empty").Envar("OS_CLOUD").String()
+	configFile         = kingpin.Flag("config.file", "Path to a configuration file listing the targets to scrape, overrides --os.cloud").String()
+	collectionInterval = kingpin.Flag("collection.interval", "Collect the metrics in the background at this interval and serve the latest ones on /metrics, scrapes collect them on demand when 0").Default("0s").Duration()
+	collectionTimeout  = kingpin.Flag("collection.timeout", "Cancel a background collection once it takes longer, no timeout when 0").Default("0s").Duration()
+	timeoutOffset      = kingpin.Flag("timeout-offset", "Offset to subtract from the timeout of Prometheus, to still send the metrics collected so far").Default("500ms").Duration()
 )
 
 func main() {
@@ -33,13 +35,44 @@ func main() {
 
 	lib.SetLogger(logger)
 
-	openStack := lib.NewOpenStackCollector(*volumeLimit)
-	// Custom registry to not collect all go low-level metrics
-	promRegistry := prometheus.NewRegistry()
-	promRegistry.MustRegister(openStack)
-	handler := promhttp.HandlerFor(promRegistry, promhttp.HandlerOpts{})
+	if err := lib.ValidateFlags(); err != nil {
+		level.Error(logger).Log("message", "Invalid flags", "err", err)
+		os.Exit(1)
+	}
+
+	exporterConfig := &lib.Config{Targets: []lib.TargetConfig{{Cloud: *osCloud}}}
+	if *configFile != "" {
+		var err error
+		exporterConfig, err = lib.LoadConfig(*configFile)
+		if err != nil {
+			level.Error(logger).Log("message", "Failed to load configuration file", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	var targets []*lib.Target
+	for _, targetConfig := range exporterConfig.Targets {
+		target, err := lib.NewTarget(targetConfig)
+		if err != nil {
+			level.Error(logger).Log("message", "Failed to load cloud configuration", "cloud", targetConfig.Cloud, "err", err)
+			os.Exit(1)
+		}
+		targets = append(targets, target)
+	}
+
+	var poller *lib.Poller
+	if *collectionInterval > 0 {
+		var err error
+		poller, err = lib.NewPoller(targets, *collectionInterval, *collectionTimeout)
+		if err != nil {
+			level.Error(logger).Log("message", "Failed to create poller", "err", err)
+			os.Exit(1)
+		}
+		poller.Start()
+	}
 
-	http.Handle("/metrics", handler)
+	http.Handle("/metrics", lib.NewMetricsHandler(targets, poller, *timeoutOffset))
+	http.Handle("/probe", lib.NewProbeHandler(targets, exporterConfig.Modules, *timeoutOffset))
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 			 <head><title>OpenStack Exporter</title></head>